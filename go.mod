@@ -3,7 +3,9 @@ module github.com/lambdalisue/gi.bridge
 go 1.13
 
 require (
+	github.com/Microsoft/go-winio v0.4.14
 	github.com/comail/colog v0.0.0-20160416085026-fba8e7b1f46c
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 	golang.org/x/tools/gopls v0.1.7 // indirect
 )
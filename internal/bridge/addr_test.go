@@ -0,0 +1,27 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddr(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("!addr")
+	h.expect("a:" + h.addr)
+}
+
+func TestAddrBeforeListen(t *testing.T) {
+	h := startOn(t, "127.0.0.1:0", WithLazyListen(true))
+	defer h.close()
+
+	// Nothing is bound yet
+	h.send("!addr")
+	h.quiet(100 * time.Millisecond)
+	h.send("!listen")
+	addr := h.expectPrefix("a:")
+	h.send("!addr")
+	h.expect(addr)
+}
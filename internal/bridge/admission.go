@@ -0,0 +1,182 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	prefixAdmitted = "admitted"
+
+	// maxQueuedRead is how many bytes are read ahead from a queued
+	// connection while watching whether the peer closes.
+	maxQueuedRead = 4096
+)
+
+// slots is the max connections slots. A slot which frees is handed over to
+// the connections waiting for it in the order they started to wait.
+type slots struct {
+	mu      sync.Mutex
+	free    int
+	waiters []chan struct{}
+}
+
+func newSlots(n int) *slots {
+	return &slots{free: n}
+}
+
+// reserve takes a free slot unless another connection waits for it, and
+// returns true. Otherwise it queues a waiter when queue is true and returns a
+// channel closed once a slot is handed over to it.
+func (s *slots) reserve(queue bool) (chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.free > 0 && len(s.waiters) == 0 {
+		s.free--
+		return nil, true
+	}
+	if !queue {
+		return nil, false
+	}
+	ready := make(chan struct{})
+	s.waiters = append(s.waiters, ready)
+	return ready, false
+}
+
+// cancel dequeues the waiter. It returns false when a slot has already been
+// handed over to the waiter, which must release it then.
+func (s *slots) cancel(ready chan struct{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.waiters {
+		if w == ready {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release hands the slot over to the first waiter, or frees it.
+func (s *slots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiters) == 0 {
+		s.free++
+		return
+	}
+	close(s.waiters[0])
+	s.waiters = s.waiters[1:]
+}
+
+// admitQueued reserves one of the max connections slots for the accepted
+// connection, or a place in the queue for it, in the order of acceptance.
+// The returned function holds the connection until the slot is handed over,
+// for up to the admission timeout, then admits and serves it. The connection
+// is rejected with 'timeout' when no slot frees in time, with 'closed' when
+// the peer closes while waiting, or with 'full' immediately when the timeout
+// is zero.
+func (b *bridge) admitQueued(ctx context.Context, g *errgroup.Group, c *connection) func() error {
+	start := b.clock.Now()
+	ready, taken := b.slots.reserve(b.admissionTimeout > 0)
+	return func() error {
+		err := b.waitAdmission(ctx, g, c, start, ready, taken)
+		if err != nil && ctx.Err() != nil {
+			// The bridge is shutting down while the connection waits
+			return nil
+		}
+		return err
+	}
+}
+
+func (b *bridge) waitAdmission(ctx context.Context, g *errgroup.Group, c *connection, start time.Time, ready chan struct{}, taken bool) error {
+	if !taken {
+		if ready == nil {
+			return b.reject(ctx, c, "full")
+		}
+		gone, stop := watchQueued(c)
+		reason := ""
+		select {
+		case <-ready:
+		case <-b.clock.After(b.admissionTimeout):
+			reason = "timeout"
+		case <-gone:
+			reason = "closed"
+		case <-ctx.Done():
+		}
+		stop()
+		if reason != "" || ctx.Err() != nil {
+			if !b.slots.cancel(ready) {
+				b.slots.release()
+			}
+			if reason == "" {
+				c.Close()
+				return nil
+			}
+			return b.reject(ctx, c, reason)
+		}
+	}
+	// The slot is released by disconnect once registered
+	c.slot = true
+	ok, err := b.admit(ctx, c)
+	if !ok {
+		b.slots.release()
+	}
+	if err != nil || !ok {
+		return err
+	}
+	wait := b.clock.Now().Sub(start)
+	if err := b.emit(ctx, "%s:%s:%d", prefixAdmitted, c.id, wait/time.Millisecond); err != nil {
+		return err
+	}
+	return b.serve(ctx, g, c)
+}
+
+// watchQueued reads the queued connection ahead to notice that the peer
+// closes, which closes gone. stop stops reading and puts back what has been
+// read so that the connection reads it first once admitted.
+func watchQueued(c *connection) (gone <-chan struct{}, stop func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	var buf bytes.Buffer
+	go func() {
+		defer close(done)
+		p := make([]byte, maxQueuedRead)
+		for buf.Len() < maxQueuedRead {
+			n, err := c.Conn.Read(p[:maxQueuedRead-buf.Len()])
+			buf.Write(p[:n])
+			if err != nil {
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					close(closed)
+				}
+				return
+			}
+		}
+	}()
+	return closed, func() {
+		// Interrupt the read with a deadline in the past
+		_ = c.Conn.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if buf.Len() > 0 {
+			c.Conn = &queuedConn{Conn: c.Conn, r: io.MultiReader(&buf, c.Conn)}
+		}
+	}
+}
+
+// queuedConn is a connection which reads what has been read ahead while it
+// was queued first.
+type queuedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *queuedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,139 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+const testAdmissionTimeout = 10 * time.Second
+
+func startAdmission(t *testing.T, clock *fakeClock) *harness {
+	t.Helper()
+	return start(t, WithClock(clock), WithMaxConnections(1), WithAdmissionTimeout(testAdmissionTimeout))
+}
+
+// admitted dials a peer and waits until it is admitted without waiting.
+func (h *harness) admitted() (net.Conn, string) {
+	h.t.Helper()
+	conn := h.dialRaw()
+	id := localPort(conn)
+	h.expect(prefixAdmitted + delim + id + delim + "0")
+	h.expect(prefixConnect + delim + id)
+	return conn, id
+}
+
+func TestAdmissionAfterWait(t *testing.T) {
+	clock := newFakeClock()
+	h := startAdmission(t, clock)
+	defer h.close()
+	first, firstID := h.admitted()
+
+	second := h.dialRaw()
+	defer second.Close()
+	secondID := localPort(second)
+	clock.waitTimers(t, 1)
+	// Data sent while waiting is received once admitted
+	if _, err := second.Write([]byte("early\n")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(3 * time.Second)
+	h.quiet(50 * time.Millisecond)
+
+	first.Close()
+	h.expect(prefixDisconnect + delim + firstID)
+	h.expect(prefixAdmitted + delim + secondID + delim + "3000")
+	h.expect(prefixConnect + delim + secondID)
+	h.expect(prefixReceive + delim + secondID + delim + "early")
+	if _, err := second.Write([]byte("late\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + secondID + delim + "late")
+}
+
+func TestAdmissionTimeout(t *testing.T) {
+	clock := newFakeClock()
+	h := startAdmission(t, clock)
+	defer h.close()
+	first, _ := h.admitted()
+	defer first.Close()
+
+	second := h.dialRaw()
+	defer second.Close()
+	clock.waitTimers(t, 1)
+	clock.Advance(testAdmissionTimeout - time.Millisecond)
+	h.quiet(50 * time.Millisecond)
+	clock.Advance(time.Millisecond)
+	h.expect(prefixReject + delim + second.LocalAddr().String() + delim + "timeout")
+	assertClosed(t, second)
+}
+
+func TestAdmissionOrder(t *testing.T) {
+	clock := newFakeClock()
+	h := startAdmission(t, clock)
+	defer h.close()
+	first, firstID := h.admitted()
+
+	// Accepted back to back, they queue in the order of acceptance
+	second := h.dialRaw()
+	defer second.Close()
+	third := h.dialRaw()
+	defer third.Close()
+	clock.waitTimers(t, 2)
+
+	first.Close()
+	h.expect(prefixDisconnect + delim + firstID)
+	h.expect(prefixAdmitted + delim + localPort(second) + delim + "0")
+	h.expect(prefixConnect + delim + localPort(second))
+	second.Close()
+	h.expect(prefixDisconnect + delim + localPort(second))
+	h.expect(prefixAdmitted + delim + localPort(third) + delim + "0")
+	h.expect(prefixConnect + delim + localPort(third))
+}
+
+func TestAdmissionPeerClosesWhileWaiting(t *testing.T) {
+	clock := newFakeClock()
+	h := startAdmission(t, clock)
+	defer h.close()
+	first, firstID := h.admitted()
+
+	second := h.dialRaw()
+	clock.waitTimers(t, 1)
+	second.Close()
+	h.expect(prefixReject + delim + second.LocalAddr().String() + delim + "closed")
+
+	// The slot is not handed over to the closed connection
+	first.Close()
+	h.expect(prefixDisconnect + delim + firstID)
+	third, _ := h.admitted()
+	defer third.Close()
+}
+
+func TestAdmissionFull(t *testing.T) {
+	h := start(t, WithMaxConnections(1))
+	defer h.close()
+	first, _ := h.admitted()
+	defer first.Close()
+
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect(prefixReject + delim + second.LocalAddr().String() + delim + "full")
+	assertClosed(t, second)
+}
+
+func TestAdmissionShutdownWhileWaiting(t *testing.T) {
+	clock := newFakeClock()
+	h := startAdmission(t, clock)
+	defer h.close()
+	first, _ := h.admitted()
+	defer first.Close()
+
+	second := h.dialRaw()
+	defer second.Close()
+	clock.waitTimers(t, 1)
+	h.cancel()
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+	assertClosed(t, second)
+}
@@ -2,15 +2,24 @@ package bridge
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/lambdalisue/gi.bridge/internal/pkg/cryptio"
 	"github.com/lambdalisue/gi.bridge/internal/pkg/ctxio"
 )
 
@@ -20,92 +29,607 @@ const (
 	prefixConnect    = "c"
 	prefixDisconnect = "d"
 	prefixReceive    = "r"
+	prefixReject     = "reject"
+	prefixAccept     = "accept"
+	prefixLast       = "last"
+	prefixQuotaWarn  = "quota-warn"
+	prefixEncoding   = "encoding"
+	prefixPeer       = "peer"
+	prefixMeta       = "meta"
+	prefixError      = "e"
+	prefixRepeat     = "rrep"
+	prefixSync       = "sync"
+
+	prefixErrors      = "errors"
+	prefixErrorRecord = "err"
+	prefixCommand     = "!"
+
+	prefixDisconnectBatch = "dbatch"
+	prefixShutdownSummary = "shutdown-summary"
+
+	streamPlaceholder = "{stream}"
+
+	defaultSendQueueSize = 64
+	greetingTimeout      = 5 * time.Second
+	shutdownTimeout      = time.Second
+	reattachTimeout      = 5 * time.Second
+
+	controlKeyLabelIn  = "gi.bridge editor to bridge"
+	controlKeyLabelOut = "gi.bridge bridge to editor"
 )
 
 type bridge struct {
-	in       io.ReadCloser
-	out      io.WriteCloser
-	listener net.Listener
-	connMap  map[string]net.Conn
+	// The counters are accessed atomically and placed first to be 64-bit
+	// aligned.
+	slowCount  uint64
+	totalIn    uint64
+	totalOut   uint64
+	errorCount uint64
+	dropCount  uint64
+	// unsentCount and unwrittenCount are the numbers of data dropped by the
+	// shutdown; data queued to peers and frames received from peers which
+	// are not written to out respectively.
+	unsentCount    uint64
+	unwrittenCount uint64
+	// incomingSince is accessed atomically; it is the time in UnixNano when
+	// handleIncoming started to handle the current line, or 0 while waiting.
+	incomingSince int64
+
+	// stalledSince is incomingSince of the line which has been warned as
+	// stalled, or 0.
+	stallMu      sync.Mutex
+	stalledSince int64
+
+	inMu      sync.Mutex
+	in        io.Reader
+	inSwapped chan struct{}
+	// lineMu is held while a line from 'in' is handled. inStopped is set
+	// once Start stops waiting for handleIncoming, so that no line is
+	// handled after Start returns.
+	lineMu    sync.Mutex
+	inStopped bool
+	// draining is closed once the shutdown begins with WithDrainIncoming,
+	// and readIncoming closes drained once the buffered lines are handled.
+	drainIncoming bool
+	draining      chan struct{}
+	drained       chan struct{}
+	out           io.Writer
+	outMu         sync.Mutex
+	// announced is the connections whose 'c' line has been written but
+	// whose 'd' line has not, guarded by outMu.
+	announced map[string]*connection
+	listenMu  sync.Mutex
+	listener  net.Listener
+	connMu    sync.Mutex
+
+	// inherited is a listener given by WithListener.
+	inherited net.Listener
+	connMap   map[string]*connection
+	connSeq   uint64
+
+	// acceptPaused is accessed atomically; new connections are rejected
+	// while it is 1.
+	acceptPaused int32
+
+	testInject    bool
+	writeRetry    int
+	single        SingleConnectionPolicy
+	connKeyFormat func(ConnInfo) string
+	customConnKey bool
+	validatePort  bool
+	readyFile     string
+	readyCreated  bool
+	lazyListen    bool
+	listenReq     chan struct{}
+	sendQueueSize int
+	sendNewline   bool
+	slowThreshold time.Duration
+	clock         Clock
+	frameIndex    bool
+	tcpNoDelay    bool
+	controlKey    []byte
+	stallTimeout  time.Duration
+	replyTimeout  time.Duration
+	statsInterval time.Duration
+	lastFrameSize int
+	dedupWindow   int
+	streamHeader  string
+	byteQuota     uint64
+
+	maxConnections   int
+	admissionTimeout time.Duration
+	slots            *slots
+
+	defaultRoute string
+
+	inflightMu   sync.Mutex
+	inflightCond *sync.Cond
+	inflight     int
+	maxInFlight  int
+
+	errRing        *errorRing
+	events         *events
+	textSuppressed bool
+	socketMode     os.FileMode
+	greeting       []byte
+
+	disconnectBatch time.Duration
+	dbatchMu        sync.Mutex
+	dbatch          []disconnection
+	dbatchTimer     Timer
+
+	// The encodings are changed only while out is locked (see '!encoding').
+	inboundEncoding  Encoding
+	outboundEncoding Encoding
 }
 
-func New(in io.ReadCloser, out io.WriteCloser) *bridge {
-	return &bridge{
-		in:      in,
-		out:     out,
-		connMap: make(map[string]net.Conn),
+func New(in io.ReadCloser, out io.WriteCloser, opts ...Option) *bridge {
+	b := &bridge{
+		in:            in,
+		inSwapped:     make(chan struct{}),
+		draining:      make(chan struct{}),
+		drained:       make(chan struct{}),
+		out:           out,
+		announced:     make(map[string]*connection),
+		connMap:       make(map[string]*connection),
+		listenReq:     make(chan struct{}, 1),
+		writeRetry:    defaultWriteRetry,
+		connKeyFormat: defaultConnKeyFormat,
+		sendQueueSize: defaultSendQueueSize,
+		sendNewline:   true,
+		tcpNoDelay:    true,
+		replyTimeout:  defaultReplyTimeout,
+		clock:         realClock{},
+
+		inboundEncoding:  EncodingRaw,
+		outboundEncoding: EncodingRaw,
+	}
+	b.inflightCond = sync.NewCond(&b.inflightMu)
+	for _, opt := range opts {
+		opt(b)
 	}
+	if b.maxInFlight > b.sendQueueSize {
+		b.maxInFlight = b.sendQueueSize
+	}
+	return b
 }
 
 func (b *bridge) Start(ctx context.Context, addr string) error {
+	defer b.closeEvents()
+	stop := ctx.Done()
+	if b.drainIncoming {
+		// Keep the handlers running after ctx is done until the lines
+		// buffered by then are handled (see drain).
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	g, ctx := errgroup.WithContext(ctx)
-
-	// Listen
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen TCP on %s: %w", addr, err)
+	if b.drainIncoming {
+		go func() {
+			select {
+			case <-stop:
+				b.drain(ctx)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
 	}
-	b.listener = l
 
-	// Notify address
-	w := bufio.NewWriter(ctxio.Writer(ctx, b.out))
-	if _, err := w.WriteString(fmt.Sprintf("%s:%s\n", prefixAddress, l.Addr())); err != nil {
-		return fmt.Errorf("failed to write listen address %s: %w", l.Addr(), err)
+	// Encrypt control channel
+	if b.controlKey != nil {
+		in, out, session, err := b.encryptControl(b.in, b.out)
+		if err != nil {
+			return err
+		}
+		if err := cryptio.WriteSession(b.out, session); err != nil {
+			return fmt.Errorf("failed to write control channel session: %w", err)
+		}
+		b.in, b.out = in, out
 	}
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("failed to flush: %w", err)
+
+	// Remove the ready file which bind creates
+	defer func() {
+		if !b.readyCreated {
+			return
+		}
+		if err := os.Remove(b.readyFile); err != nil {
+			log.Printf("warn: failed to remove ready file %s: %s", b.readyFile, err)
+		}
+	}()
+
+	// Listen now, or on '!listen' in lazy listen mode
+	if !b.lazyListen {
+		if err := b.bind(ctx, addr); err != nil {
+			return err
+		}
 	}
 
 	// Start handlers
 	g.Go(func() error {
-		return b.handleIncoming(ctx)
+		// Reading 'in' cannot be interrupted so do not wait handleIncoming
+		// once the context is done.
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- b.handleIncoming(ctx)
+		}()
+		select {
+		case err := <-errCh:
+			// Reaching EOF of 'in' means that the editor has gone.
+			cancel()
+			return err
+		case <-ctx.Done():
+			// Wait for the line being handled so that nothing is written
+			// after the shutdown summary.
+			b.stopIncoming()
+			return nil
+		}
 	})
 	g.Go(func() error {
+		if b.lazyListen {
+			if err := b.bindLazily(ctx, addr); err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
 		return b.handleAccept(ctx, g)
 	})
-	return g.Wait()
+	g.Go(func() error {
+		<-ctx.Done()
+		b.wakeInFlight()
+		b.shutdown()
+		return nil
+	})
+	if b.stallTimeout > 0 {
+		g.Go(func() error {
+			return b.watchIncoming(ctx)
+		})
+	}
+	if b.statsInterval > 0 {
+		g.Go(func() error {
+			return b.emitStats(ctx)
+		})
+	}
+	err := g.Wait()
+	b.summarize()
+	return err
 }
 
-func (b *bridge) handleIncoming(ctx context.Context) error {
-	r := bufio.NewReader(ctxio.Reader(ctx, b.in))
+// encryptControl wraps in and out to encrypt the control channel in a new
+// session, which must be written to out before any sealed line.
+func (b *bridge) encryptControl(in io.Reader, out io.Writer) (io.Reader, io.Writer, []byte, error) {
+	session, err := cryptio.NewSession()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create control channel session: %w", err)
+	}
+	if in, err = cryptio.Reader(in, b.controlKey, controlKeyLabelIn, session); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to encrypt control channel: %w", err)
+	}
+	if out, err = cryptio.Writer(out, b.controlKey, controlKeyLabelOut, session); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to encrypt control channel: %w", err)
+	}
+	return in, out, session, nil
+}
+
+// bind listens on addr, creates the ready file, and notifies the address.
+func (b *bridge) bind(ctx context.Context, addr string) error {
+	l, err := b.listenOn(addr)
+	if err != nil {
+		return err
+	}
+	return b.publishListener(ctx, l)
+}
+
+// bindLazily binds on every '!listen' until it succeeds. A failure is
+// notified by an 'a:error:<reason>' line instead of stopping the bridge, so
+// that the editor can retry '!listen' once the address is available.
+func (b *bridge) bindLazily(ctx context.Context, addr string) error {
 	for {
-		data, err := r.ReadString('\n')
+		select {
+		case <-b.listenReq:
+		case <-ctx.Done():
+			return nil
+		}
+		l, err := b.listenOn(addr)
+		if err == nil {
+			return b.publishListener(ctx, l)
+		}
+		log.Printf("warn: %s", err)
+		if err := b.emit(ctx, "%s:error:%s", prefixAddress, lineBreakEscaper.Replace(err.Error())); err != nil {
+			return fmt.Errorf("failed to write listen error on %s: %w", addr, err)
+		}
+	}
+}
+
+// listenOn listens on addr unless a listener is given, and creates the ready
+// file.
+func (b *bridge) listenOn(addr string) (net.Listener, error) {
+	l := b.inherited
+	if l == nil {
+		var err error
+		l, err = listen(addr, b.socketMode)
 		if err != nil {
-			if err == io.EOF || err == io.ErrClosedPipe {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+	}
+
+	// Notify readiness
+	if b.readyFile != "" {
+		if err := createFileAtomic(b.readyFile); err != nil {
+			if l != b.inherited {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("failed to create ready file %s: %w", b.readyFile, err)
+		}
+		b.readyCreated = true
+	}
+	return l, nil
+}
+
+// publishListener publishes the listener and notifies the address.
+func (b *bridge) publishListener(ctx context.Context, l net.Listener) error {
+	// Publish the listener after the ready file is created so that the
+	// ready file exists whenever the listener does.
+	b.listenMu.Lock()
+	b.listener = l
+	b.listenMu.Unlock()
+	if ctx.Err() != nil {
+		// shutdown may have already run without the listener
+		_ = l.Close()
+		return nil
+	}
+
+	// Notify address
+	if err := b.emit(ctx, "%s:%s", prefixAddress, l.Addr()); err != nil {
+		return fmt.Errorf("failed to write listen address %s: %w", l.Addr(), err)
+	}
+	return nil
+}
+
+// getListener returns the listener, or nil when it is not bound yet.
+func (b *bridge) getListener() net.Listener {
+	b.listenMu.Lock()
+	defer b.listenMu.Unlock()
+	return b.listener
+}
+
+// shutdown stops accepting new connections and closes all connections.
+func (b *bridge) shutdown() {
+	if l := b.getListener(); l != nil {
+		if err := l.Close(); err != nil {
+			log.Printf("warn: failed to close listener: %s", err)
+		}
+	}
+	ctx, cancel := finalContext()
+	defer cancel()
+	for _, c := range b.connections() {
+		b.disconnect(ctx, c, "shutdown")
+	}
+}
+
+// finalContext returns a context to notify the editor of the final state
+// once the context of the bridge is done, since out is still open until Start
+// returns. It is bounded so that a stalled out never blocks the shutdown.
+func finalContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), shutdownTimeout)
+}
+
+// summarize notifies the disconnections still batched and the numbers of
+// data dropped by the shutdown once every connection has stopped.
+func (b *bridge) summarize() {
+	ctx, cancel := finalContext()
+	defer cancel()
+	b.flushDisconnects(ctx)
+	unsent := atomic.LoadUint64(&b.unsentCount)
+	unwritten := atomic.LoadUint64(&b.unwrittenCount)
+	if err := b.emit(ctx, "%s:%d:%d", prefixShutdownSummary, unsent, unwritten); err != nil && !isClosedPipe(err) {
+		log.Printf("warn: failed to write shutdown summary: %s", err)
+	}
+}
+
+func (b *bridge) handleIncoming(ctx context.Context) error {
+	for {
+		in, swapped := b.input()
+		if err := b.readIncoming(ctx, in, swapped); err != errReattached {
+			return err
+		}
+	}
+}
+
+// errReattached is returned by readIncoming when 'in' has been replaced.
+var errReattached = errors.New("'in' has been replaced")
+
+// readIncoming handles lines from in until it fails, reaches EOF, or is
+// replaced by Reattach, which closes swapped.
+//
+// Reading in cannot be interrupted, so lines are read by another goroutine
+// which drops what it reads after in has been replaced.
+func (b *bridge) readIncoming(ctx context.Context, in io.Reader, swapped <-chan struct{}) error {
+	lines := make(chan bufferedLine)
+	errc := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(ctxio.Reader(ctx, in))
+		for {
+			data, err := r.ReadString('\n')
+			if err != nil {
+				errc <- err
+				return
+			}
+			p, _ := r.Peek(r.Buffered())
+			line := bufferedLine{data: data, more: bytes.IndexByte(p, '\n') >= 0}
+			select {
+			case lines <- line:
+			case <-swapped:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	more := false
+	for {
+		// Stop once no line is buffered after the shutdown began, since
+		// a buffered line is sent by the goroutine without blocking.
+		draining := b.draining
+		if more {
+			draining = nil
+		}
+		var data string
+		select {
+		case line := <-lines:
+			data, more = line.data, line.more
+		case <-draining:
+			close(b.drained)
+			<-ctx.Done()
+			return nil
+		case err := <-errc:
+			select {
+			case <-swapped:
+				// EOF of the previous 'in' does not stop the bridge
+				return errReattached
+			default:
+			}
+			if err == io.EOF || err == io.ErrClosedPipe || errors.Is(err, syscall.ECONNRESET) {
 				// XXX: Are you sure?
 				return nil
 			}
 			return fmt.Errorf("error: failed to read incoming data: %w", err)
+		case <-swapped:
+			return errReattached
+		}
+		select {
+		case <-swapped:
+			// The line is from the previous 'in'
+			return errReattached
+		default:
+		}
+		b.lineMu.Lock()
+		if b.inStopped {
+			b.lineMu.Unlock()
+			return nil
 		}
 		text := strings.TrimSpace(data)
-		// Find which port
-		m := strings.SplitN(text, delim, 2)
+		since := b.clock.Now().UnixNano()
+		atomic.StoreInt64(&b.incomingSince, since)
+		err := b.handleLine(ctx, text)
+		atomic.StoreInt64(&b.incomingSince, 0)
+		b.recoverIncoming(ctx, since)
+		b.lineMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stopIncoming waits for the line being handled and stops handling lines
+// from 'in'.
+func (b *bridge) stopIncoming() {
+	b.lineMu.Lock()
+	defer b.lineMu.Unlock()
+	b.inStopped = true
+}
+
+// handleLine handles a line from 'in' which is either a control command or
+// data sent to a peer.
+func (b *bridge) handleLine(ctx context.Context, text string) error {
+	if strings.HasPrefix(text, prefixCommand) {
+		return b.handleCommand(ctx, strings.TrimPrefix(text, prefixCommand))
+	}
+	// Find which port
+	m := strings.SplitN(text, delim, 2)
+	if len(m) != 2 {
+		log.Printf("warn: the incoming data does not follow the syntax (port:expr): %s", text)
+		return nil
+	}
+	port := m[0]
+	expr := m[1]
+	if ctx.Err() != nil {
+		// Lines buffered before the shutdown began are still handled here.
+		// Tell the editor that they are not delivered with a context
+		// which is not done, since out is open until Start returns.
+		ctx, cancel := finalContext()
+		defer cancel()
+		_ = b.emit(ctx, "%s:%s:shutting-down", prefixError, port)
+		return nil
+	}
+	if b.validatePort && !b.customConnKey {
+		if _, err := strconv.ParseUint(port, 10, 64); err != nil {
+			return b.emit(ctx, "%s:%s:invalid-port", prefixError, port)
+		}
+	}
+	stream := ""
+	if b.streamHeader != "" {
+		m := strings.SplitN(expr, delim, 2)
 		if len(m) != 2 {
-			log.Printf("warn: the incoming data does not follow the syntax (port:expr): %s", text)
-			continue
+			log.Printf("warn: the incoming data does not follow the syntax (port:stream:expr): %s", text)
+			return nil
 		}
-		port := m[0]
-		expr := m[1]
-		conn, ok := b.connMap[port]
-		if !ok {
-			log.Printf("warn: no connection exists for %s", port)
-			continue
+		stream = m[0]
+		expr = m[1]
+	}
+	conn, ok := b.lookup(port)
+	routed := false
+	if !ok && b.defaultRoute != "" {
+		conn, ok = b.lookup(b.defaultRoute)
+		routed = ok
+	}
+	if !ok {
+		b.fail(ctx, nil, port, fmt.Errorf("no connection exists for %s", port))
+		return nil
+	}
+	p, err := b.inboundEncoding.decode(expr)
+	if err != nil {
+		b.fail(ctx, conn, port, fmt.Errorf("failed to decode %d bytes of data to %s: %w", len(expr), port, err))
+		return nil
+	}
+	if b.streamHeader != "" {
+		header := strings.Replace(b.streamHeader, streamPlaceholder, stream, -1)
+		p = append([]byte(header), p...)
+	}
+	if routed {
+		// Tell the default connection which port the data was intended for
+		p = append([]byte(port+delim), p...)
+	}
+	p = b.frame(p)
+	if !b.acquire(ctx, conn) {
+		if ctx.Err() != nil {
+			// The shutdown began while waiting for the peer to drain
+			ctx, cancel := finalContext()
+			defer cancel()
+			_ = b.emit(ctx, "%s:%s:shutting-down", prefixError, port)
+			return nil
 		}
-		if _, err := conn.Write([]byte(expr)); err != nil {
-			log.Printf("warn: failed to write data %s to %s: %s", expr, port, err)
-			continue
+		atomic.AddUint64(&b.dropCount, 1)
+		b.fail(ctx, conn, port, fmt.Errorf("failed to enqueue %d bytes of data to %s: the connection is closed", len(p), port))
+		return nil
+	}
+	if !conn.enqueue(p) {
+		b.release()
+		atomic.AddUint64(&b.dropCount, 1)
+		if conn.isClosed() {
+			b.fail(ctx, conn, port, fmt.Errorf("failed to enqueue %d bytes of data to %s: the connection is closed", len(p), port))
+			return nil
 		}
+		b.fail(ctx, conn, port, fmt.Errorf("failed to enqueue %d bytes of data to %s: the send queue is full", len(p), port))
+		// Tell the editor since the data is lost
+		return b.emit(ctx, "%s:%s:queue-full", prefixError, port)
 	}
+	return nil
 }
 
 func (b *bridge) handleAccept(ctx context.Context, g *errgroup.Group) error {
-	if b.listener == nil {
+	listener := b.getListener()
+	if listener == nil {
 		return fmt.Errorf("'listener' is nil and handleAccept must be called after proper initialization")
 	}
-	listener := b.listener
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			if ne, ok := err.(net.Error); ok {
 				if ne.Temporary() {
 					continue
@@ -113,56 +637,316 @@ func (b *bridge) handleAccept(ctx context.Context, g *errgroup.Group) error {
 			}
 			return fmt.Errorf("failed to accept connection by non tempoary error: %w", err)
 		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			if err := tc.SetNoDelay(b.tcpNoDelay); err != nil {
+				log.Printf("warn: failed to set TCP_NODELAY of %s: %s", conn.RemoteAddr(), err)
+			}
+		}
 		// Register conn
-		_, port, err := net.SplitHostPort(conn.RemoteAddr().String())
-		if err != nil {
-			return fmt.Errorf("failed to split remote addr: %s: %w", conn.RemoteAddr(), err)
+		b.connSeq++
+		id := b.connKeyFormat(ConnInfo{
+			Seq:        b.connSeq,
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+		})
+		c := newConnection(conn, id, b.sendQueueSize)
+		if b.maxConnections > 0 {
+			// Wait for a slot without blocking accepting others
+			g.Go(b.admitQueued(ctx, g, c))
+			continue
 		}
-		b.connMap[port] = conn
-		// Notify port
-		w := bufio.NewWriter(ctxio.Writer(ctx, b.out))
-		if _, err := w.WriteString(fmt.Sprintf("%s:%s\n", prefixConnect, port)); err != nil {
-			return fmt.Errorf("failed to write connected remote port %s: %w", port, err)
+		ok, err := b.admit(ctx, c)
+		if err == nil && ok {
+			err = b.serve(ctx, g, c)
 		}
-		if err := w.Flush(); err != nil {
-			return fmt.Errorf("failed to flush: %w", err)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The bridge is shutting down while the connection is
+				// being admitted, and shutdown closes it.
+				c.Close()
+				return nil
+			}
+			return err
 		}
-		// Start handling outgoing messages
-		g.Go(func() error {
-			return b.handleOutgoing(ctx, port, conn)
+	}
+}
+
+// serve notifies the admitted connection and starts handling it.
+func (b *bridge) serve(ctx context.Context, g *errgroup.Group, c *connection) error {
+	// Notify port
+	b.publish(ctx, Event{Type: EventConnect, ID: c.id, Addr: c.RemoteAddr()})
+	if !b.textSuppressed {
+		// Disconnections batched so far precede the connection, which may
+		// replace one of them or reuse its key.
+		b.dbatchMu.Lock()
+		b.emitBatch(ctx)
+		err := b.emitLine(ctx, func() string {
+			b.announced[c.id] = c
+			return fmt.Sprintf("%s:%s", prefixConnect, c.id)
 		})
+		b.dbatchMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to write connected remote port %s: %w", c.id, err)
+		}
 	}
+	// Start handling outgoing messages
+	g.Go(func() error {
+		return b.handleOutgoing(ctx, c)
+	})
+	g.Go(func() error {
+		return b.handleSend(ctx, c)
+	})
+	return nil
 }
 
-func (b *bridge) handleOutgoing(ctx context.Context, port string, conn net.Conn) error {
-	r := bufio.NewReader(conn)
-	w := bufio.NewWriter(ctxio.Writer(ctx, b.out))
+// admit registers the connection unless accepting is paused, its key is not
+// valid or unique, or the single connection policy rejects it. It returns false when
+// the connection has been rejected.
+func (b *bridge) admit(ctx context.Context, c *connection) (bool, error) {
+	if atomic.LoadInt32(&b.acceptPaused) == 1 {
+		return false, b.reject(ctx, c, "paused")
+	}
+	if !validKey(c.id) {
+		log.Printf("warn: the connection key %q of %s is not valid", c.id, c.RemoteAddr())
+		return false, b.reject(ctx, c, "invalid-key")
+	}
+	b.connMu.Lock()
+	if _, dup := b.connMap[c.id]; dup {
+		b.connMu.Unlock()
+		log.Printf("warn: the connection key %q of %s is not unique", c.id, c.RemoteAddr())
+		return false, b.reject(ctx, c, "duplicate")
+	}
+	var existing []*connection
+	if b.single != SingleConnectionNone {
+		for _, e := range b.connMap {
+			existing = append(existing, e)
+		}
+	}
+	if len(existing) == 0 || b.single == SingleConnectionReplace {
+		b.connMap[c.id] = c
+	}
+	b.connMu.Unlock()
+
+	if len(existing) == 0 {
+		return true, nil
+	}
+	switch b.single {
+	case SingleConnectionReplace:
+		for _, e := range existing {
+			b.disconnect(ctx, e, "replaced")
+		}
+		return true, nil
+	default:
+		return false, b.reject(ctx, c, "busy")
+	}
+}
+
+// reject closes the connection which has not been registered and notifies
+// the rejection with the reason.
+func (b *bridge) reject(ctx context.Context, c *connection, reason string) error {
+	c.Close()
+	atomic.AddUint64(&b.errorCount, 1)
+	// The remote address has ':' which separates the fields of 'err' lines
+	id := c.id
+	if !validKey(id) {
+		id = ""
+	}
+	b.recordError(id, fmt.Sprintf("rejected %s: %s", c.RemoteAddr(), reason))
+	if err := b.emit(ctx, "%s:%s:%s", prefixReject, c.RemoteAddr(), reason); err != nil {
+		return fmt.Errorf("failed to write rejected remote addr %s: %w", c.RemoteAddr(), err)
+	}
+	return nil
+}
+
+func (b *bridge) handleOutgoing(ctx context.Context, c *connection) error {
+	id := c.id
+	r := bufio.NewReader(c)
 	defer func() {
-		if _, err := w.WriteString(fmt.Sprintf("%s:%s\n", prefixDisconnect, port)); err != nil {
-			log.Printf("warn: failed to write disconnection from %s: %s", conn, err)
+		if ctx.Err() != nil {
+			// Reading has been stopped by the shutdown, which may not have
+			// closed the connection yet.
+			ctx, cancel := finalContext()
+			defer cancel()
+			b.disconnect(ctx, c, "shutdown")
+			return
+		}
+		b.disconnect(ctx, c, "")
+	}()
+	var index uint64
+	// render renders data as in 'r' lines and retains it as the last frame.
+	// The caller must lock outMu.
+	render := func(data string) string {
+		text := b.encodeFrame(c, data)
+		if b.frameIndex {
+			index++
+			text = fmt.Sprintf("%d:%s", index, text)
+		}
+		if b.lastFrameSize > 0 {
+			if len(text) <= b.lastFrameSize {
+				c.setLast(&text)
+			} else {
+				c.setLast(nil)
+			}
 		}
-		if err := w.Flush(); err != nil {
-			log.Printf("error: failed to flush: %s", err)
+		return text
+	}
+	// prev is the last frame emitted and repeat is the number of identical
+	// frames collapsed into it since they were reported.
+	var prev string
+	var repeat int
+	flushRepeat := func() error {
+		if repeat == 0 {
+			return nil
+		}
+		n := repeat
+		repeat = 0
+		return b.emit(ctx, "%s:%s:%d", prefixRepeat, id, n)
+	}
+	defer func() {
+		if err := flushRepeat(); err != nil && ctx.Err() == nil {
+			log.Printf("warn: failed to write repeat count of %s: %s", id, err)
 		}
-		delete(b.connMap, port)
-		conn.Close()
 	}()
 	for {
 		data, err := r.ReadString('\n')
 		if err != nil {
-			if err == io.EOF || err == io.ErrClosedPipe {
+			if err == io.EOF || err == io.ErrClosedPipe || c.isClosed() || ctx.Err() != nil {
 				// XXX: Are you sure?
 				return nil
 			}
-			return fmt.Errorf("error: failed to read outgoing data: %w", err)
+			// The peer is disconnected but the others are not affected
+			log.Printf("warn: failed to read outgoing data of %s: %s", id, err)
+			return nil
 		}
-		text := strings.TrimSpace(data)
-		if _, err := w.WriteString(fmt.Sprintf("%s:%s:%s\n", prefixReceive, port, text)); err != nil {
-			log.Printf("warn: failed to write data %s from %s: %s", data, conn, err)
+		if !b.account(ctx, c, len(data), 0) {
+			return nil
+		}
+		b.publish(ctx, Event{
+			Type:    EventReceive,
+			ID:      id,
+			Addr:    c.RemoteAddr(),
+			Payload: []byte(strings.TrimSuffix(data, "\n")),
+		})
+		if r, ok := c.popRequest(); ok {
+			err = b.emitLine(ctx, func() string {
+				return fmt.Sprintf("%s:%s:%s", prefixReply, r.seq, b.encodeFrame(c, data))
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					atomic.AddUint64(&b.unwrittenCount, 1)
+					return nil
+				}
+				return fmt.Errorf("error: failed to write reply %s from %s: %w", r.seq, id, err)
+			}
+			continue
+		}
+		if b.textSuppressed {
+			// Retain the last frame without writing the 'r' line
+			b.outMu.Lock()
+			render(data)
+			b.outMu.Unlock()
 			continue
 		}
-		if err := w.Flush(); err != nil {
-			return fmt.Errorf("error: failed to flush: %w", err)
+		if b.dedupWindow > 0 {
+			// Collapse identical consecutive frames and report the count
+			// when a different frame arrives or the window is filled.
+			repeated := data == prev
+			if repeated {
+				repeat++
+			}
+			if !repeated || repeat >= b.dedupWindow {
+				if err := flushRepeat(); err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fmt.Errorf("error: failed to write repeat count of %s: %w", id, err)
+				}
+			}
+			if repeated {
+				continue
+			}
+			prev = data
+		}
+		// Encode while the line is being emitted so that the encoding never
+		// changes between encoding and emitting.
+		err = b.emitLine(ctx, func() string {
+			return fmt.Sprintf("%s:%s:%s", prefixReceive, id, render(data))
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				// The bridge is shutting down so the data is dropped
+				atomic.AddUint64(&b.unwrittenCount, 1)
+				return nil
+			}
+			return fmt.Errorf("error: failed to write data from %s: %w", id, err)
+		}
+	}
+}
+
+func (b *bridge) handleSend(ctx context.Context, c *connection) error {
+	// handleSend returns only after the connection has been closed, so
+	// nothing is enqueued anymore and the data left can be dropped.
+	defer func() {
+		for {
+			select {
+			case <-c.send:
+				b.release()
+				b.dropUnsent(ctx)
+			default:
+				return
+			}
+		}
+	}()
+	if len(b.greeting) > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(greetingTimeout))
+		err := b.writeTo(ctx, c, b.frame(b.greeting))
+		_ = c.SetWriteDeadline(time.Time{})
+		if err != nil {
+			if c.isClosed() {
+				return nil
+			}
+			b.fail(ctx, c, c.id, fmt.Errorf("failed to write greeting to %s: %w", c.id, err))
 		}
 	}
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case p := <-c.send:
+			err := b.writeTo(ctx, c, p)
+			b.release()
+			if err != nil {
+				if c.isClosed() {
+					b.dropUnsent(ctx)
+					return nil
+				}
+				b.fail(ctx, c, c.id, fmt.Errorf("failed to write %d bytes of data to %s: %w", len(p), c.id, err))
+			}
+		}
+	}
+}
+
+// dropUnsent counts data which is never sent since the connection has been
+// closed.
+func (b *bridge) dropUnsent(ctx context.Context) {
+	atomic.AddUint64(&b.dropCount, 1)
+	if ctx.Err() != nil {
+		atomic.AddUint64(&b.unsentCount, 1)
+	}
+}
+
+func (b *bridge) writeTo(ctx context.Context, c *connection, p []byte) error {
+	start := b.clock.Now()
+	n, err := c.Write(p)
+	b.observe(ctx, "write", c.id, start)
+	b.account(ctx, c, 0, n)
+	return err
+}
+
+// frame appends a newline to p if required. p itself is never modified.
+func (b *bridge) frame(p []byte) []byte {
+	if b.sendNewline && !bytes.HasSuffix(p, []byte("\n")) {
+		return append(p[:len(p):len(p)], '\n')
+	}
+	return p
 }
@@ -0,0 +1,234 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	commandAddr         = "addr"
+	commandEncoding     = "encoding"
+	commandErrors       = "errors"
+	commandGetMeta      = "getmeta"
+	commandLast         = "last"
+	commandListen       = "listen"
+	commandPeer         = "peer"
+	commandRecycle      = "recycle"
+	commandRequest      = "request"
+	commandSetMeta      = "setmeta"
+	commandSync         = "sync"
+	commandPauseAccept  = "pause-accept"
+	commandResumeAccept = "resume-accept"
+
+	commandInjectConnect    = "inject-connect"
+	commandInjectReceive    = "inject-recv"
+	commandInjectDisconnect = "inject-disconnect"
+)
+
+// handleCommand handles a control command (the incoming line without the
+// leading '!') in the form of 'name[:args]'.
+func (b *bridge) handleCommand(ctx context.Context, text string) error {
+	m := strings.SplitN(text, delim, 2)
+	name := m[0]
+	args := ""
+	if len(m) == 2 {
+		args = m[1]
+	}
+	switch name {
+	case commandInjectConnect, commandInjectReceive, commandInjectDisconnect:
+		if !b.testInject {
+			log.Printf("warn: the command %s is available only in test inject mode", name)
+			return nil
+		}
+		return b.handleInject(ctx, name, args)
+	case commandAddr:
+		l := b.getListener()
+		if l == nil {
+			log.Printf("warn: the bridge does not listen yet")
+			return nil
+		}
+		return b.emit(ctx, "%s:%s", prefixAddress, l.Addr())
+	case commandSync:
+		// The ready file exists once the listener is bound
+		if b.readyFile != "" && b.getListener() != nil {
+			if err := syncFile(b.readyFile); err != nil {
+				log.Printf("warn: failed to sync ready file %s: %s", b.readyFile, err)
+				return b.emit(ctx, "%s:error:%s", prefixSync, lineBreakEscaper.Replace(err.Error()))
+			}
+		}
+		return b.emit(ctx, "%s:ok", prefixSync)
+	case commandListen:
+		if l := b.getListener(); l != nil {
+			return b.emit(ctx, "%s:%s", prefixAddress, l.Addr())
+		}
+		select {
+		case b.listenReq <- struct{}{}:
+		default:
+		}
+		return nil
+	case commandPauseAccept:
+		atomic.StoreInt32(&b.acceptPaused, 1)
+		return b.emit(ctx, "%s:paused", prefixAccept)
+	case commandResumeAccept:
+		atomic.StoreInt32(&b.acceptPaused, 0)
+		return b.emit(ctx, "%s:resumed", prefixAccept)
+	case commandLast:
+		c, ok := b.lookup(args)
+		if !ok {
+			return b.emit(ctx, "%s:%s:unknown", prefixError, args)
+		}
+		if text, ok := c.getLast(); ok {
+			return b.emit(ctx, "%s:%s:%s", prefixLast, c.id, text)
+		}
+		return b.emit(ctx, "%s:%s", prefixLast, c.id)
+	case commandEncoding:
+		e := Encoding(args)
+		switch e {
+		case EncodingRaw, EncodingBase64:
+		default:
+			log.Printf("warn: unknown encoding: %s", args)
+			return nil
+		}
+		// The acknowledgement is the frame boundary: lines from the editor
+		// after this command and 'r' lines after the acknowledgement use
+		// the new encoding.
+		return b.emitLine(ctx, func() string {
+			b.inboundEncoding = e
+			b.outboundEncoding = e
+			return fmt.Sprintf("%s:%s", prefixEncoding, e)
+		})
+	case commandRequest:
+		m := strings.SplitN(args, delim, 3)
+		if len(m) != 3 {
+			log.Printf("warn: the command does not follow the syntax (%s:seq:id:expr): %s", name, args)
+			return nil
+		}
+		c, ok := b.lookup(m[1])
+		if !ok {
+			return b.emit(ctx, "%s:%s:unknown", prefixError, m[1])
+		}
+		p, err := b.inboundEncoding.decode(m[2])
+		if err != nil {
+			b.fail(ctx, c, c.id, fmt.Errorf("failed to decode request %s to %s: %w", m[0], c.id, err))
+			return nil
+		}
+		b.request(ctx, m[0], c, p)
+		return nil
+	case commandSetMeta:
+		m := strings.SplitN(args, delim, 3)
+		if len(m) != 3 {
+			log.Printf("warn: the command does not follow the syntax (%s:id:key:value): %s", name, args)
+			return nil
+		}
+		c, ok := b.lookup(m[0])
+		if !ok {
+			return b.emit(ctx, "%s:%s:unknown", prefixError, m[0])
+		}
+		if err := c.setMeta(m[1], m[2]); err != nil {
+			return b.emit(ctx, "%s:%s:%s", prefixError, c.id, err)
+		}
+		if m[2] == "" {
+			// Deleted
+			return b.emit(ctx, "%s:%s:%s", prefixMeta, c.id, m[1])
+		}
+		return b.emit(ctx, "%s:%s:%s:%s", prefixMeta, c.id, m[1], m[2])
+	case commandGetMeta:
+		m := strings.SplitN(args, delim, 2)
+		if len(m) != 2 {
+			log.Printf("warn: the command does not follow the syntax (%s:id:key): %s", name, args)
+			return nil
+		}
+		c, ok := b.lookup(m[0])
+		if !ok {
+			return b.emit(ctx, "%s:%s:unknown", prefixError, m[0])
+		}
+		if value, ok := c.getMeta(m[1]); ok {
+			return b.emit(ctx, "%s:%s:%s:%s", prefixMeta, c.id, m[1], value)
+		}
+		return b.emit(ctx, "%s:%s:%s", prefixMeta, c.id, m[1])
+	case commandPeer:
+		c, ok := b.lookup(args)
+		if !ok {
+			return b.emit(ctx, "%s:%s:unknown", prefixError, args)
+		}
+		report, err := json.Marshal(c.report())
+		if err != nil {
+			return fmt.Errorf("failed to marshal peer report of %s: %w", c.id, err)
+		}
+		return b.emit(ctx, "%s:%s:%s", prefixPeer, c.id, report)
+	case commandErrors:
+		return b.dumpErrors(ctx)
+	case commandRecycle:
+		var interval time.Duration
+		if args != "" {
+			d, err := time.ParseDuration(args)
+			if err != nil {
+				log.Printf("warn: the command does not follow the syntax (%s[:interval]): %s", name, args)
+				return nil
+			}
+			interval = d
+		}
+		b.recycle(ctx, interval)
+		return nil
+	default:
+		log.Printf("warn: unknown command: %s", name)
+		return nil
+	}
+}
+
+func (b *bridge) handleInject(ctx context.Context, name, args string) error {
+	id := args
+	payload := ""
+	if name == commandInjectReceive {
+		m := strings.SplitN(args, delim, 2)
+		if len(m) != 2 {
+			log.Printf("warn: the command does not follow the syntax (%s:id:payload): %s", name, args)
+			return nil
+		}
+		id, payload = m[0], m[1]
+	}
+	// Injected ids must be as well-formed as real connection keys
+	if !validKey(id) {
+		log.Printf("warn: the id %q of %s is not a valid connection key", id, name)
+		return nil
+	}
+	switch name {
+	case commandInjectConnect:
+		return b.emit(ctx, "%s:%s", prefixConnect, id)
+	case commandInjectDisconnect:
+		return b.emit(ctx, "%s:%s", prefixDisconnect, id)
+	case commandInjectReceive:
+		return b.emit(ctx, "%s:%s:%s", prefixReceive, id, payload)
+	}
+	return nil
+}
+
+// recycle closes all existing connections so that peers reconnect and pick
+// up new settings. When interval is positive, the connections are closed one
+// by one with the interval in background to avoid a reconnect storm.
+func (b *bridge) recycle(ctx context.Context, interval time.Duration) {
+	conns := b.connections()
+	if interval <= 0 {
+		for _, c := range conns {
+			b.disconnect(ctx, c, "recycle")
+		}
+		return
+	}
+	go func() {
+		for i, c := range conns {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-b.clock.After(interval):
+				}
+			}
+			b.disconnect(ctx, c, "recycle")
+		}
+	}()
+}
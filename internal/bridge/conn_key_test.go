@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestConnKeyFormat(t *testing.T) {
+	h := start(t, WithConnKeyFormat(func(info ConnInfo) string {
+		return fmt.Sprintf("conn-%d", info.Seq)
+	}))
+	defer h.close()
+
+	for i := 1; i <= 2; i++ {
+		conn := h.dialRaw()
+		defer conn.Close()
+		h.expect(fmt.Sprintf("c:conn-%d", i))
+	}
+	h.send("!peer:conn-1")
+	h.expectPrefix(`peer:conn-1:{"id":"conn-1",`)
+}
+
+func TestConnKeyFormatDuplicate(t *testing.T) {
+	h := start(t, WithConnKeyFormat(func(ConnInfo) string {
+		return "same"
+	}))
+	defer h.close()
+
+	first := h.dialRaw()
+	defer first.Close()
+	h.expect("c:same")
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("reject:" + second.LocalAddr().String() + ":duplicate")
+}
+
+func TestConnKeyFormatInvalid(t *testing.T) {
+	for _, key := range []string{"", "a:b", "a\nb", "a\rb"} {
+		key := key
+		t.Run(fmt.Sprintf("%q", key), func(t *testing.T) {
+			h := start(t, WithConnKeyFormat(func(ConnInfo) string {
+				return key
+			}))
+			defer h.close()
+
+			conn := h.dialRaw()
+			defer conn.Close()
+			h.expect("reject:" + conn.LocalAddr().String() + ":invalid-key")
+		})
+	}
+}
+
+func TestDefaultConnKeyFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want string
+	}{
+		{"tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, "12345"},
+		{"unix", &net.UnixAddr{Name: "/tmp/bridge.sock", Net: "unix"}, "3"},
+		{"unnamed unix", &net.UnixAddr{Net: "unix"}, "3"},
+		{"pipe", pipeAddr(`\\.\pipe\gi`), "3"},
+	}
+	for _, tt := range tests {
+		if got := defaultConnKeyFormat(ConnInfo{Seq: 3, RemoteAddr: tt.addr}); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// pipeAddr is the address of a Windows named pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,359 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxMetaEntries = 16
+	maxMetaSize    = 256
+)
+
+var (
+	errMetaFull     = errors.New("meta-full")
+	errMetaTooLarge = errors.New("meta-too-large")
+)
+
+// ConnInfo describes an accepted connection.
+type ConnInfo struct {
+	// Seq is a sequential number of the connection starting from 1.
+	Seq uint64
+	// LocalAddr is the local address of the connection.
+	LocalAddr net.Addr
+	// RemoteAddr is the remote address of the connection.
+	RemoteAddr net.Addr
+}
+
+// defaultConnKeyFormat renders the remote port, or the sequential number
+// when the remote address does not have a port.
+func defaultConnKeyFormat(info ConnInfo) string {
+	if _, port, err := net.SplitHostPort(info.RemoteAddr.String()); err == nil {
+		return port
+	}
+	return strconv.FormatUint(info.Seq, 10)
+}
+
+// validKey reports whether key can identify a connection in protocol lines,
+// which are separated by ':' and terminated by a newline.
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, delim+"\r\n")
+}
+
+// connection is a registered connection to a peer.
+//
+// Reading from and writing to a peer are done by dedicated goroutines
+// (handleOutgoing and handleSend) so that the bridge never deadlocks even
+// when a peer floods data while it does not read, and the editor does the
+// same at the same time. The invariants are:
+//
+//   - handleIncoming never blocks on writing to a peer. It only enqueues data
+//     to the bounded send queue and drops the data with 'e:<id>:queue-full'
+//     when the queue is full.
+//     With WithMaxInFlight, it waits while the maximum number of data is in
+//     flight across all connections to backpressure the editor, until the
+//     peers drain, the connection is closed, or the bridge shuts down.
+//   - handleSend is the only goroutine which blocks on writing to the peer.
+//   - handleOutgoing only blocks on reading from the peer and on writing to
+//     out, so it keeps draining the peer regardless of the send direction.
+type connection struct {
+	// bytesIn and bytesOut are accessed atomically and placed first to be
+	// 64-bit aligned.
+	bytesIn  uint64
+	bytesOut uint64
+
+	net.Conn
+	id          string
+	connectedAt time.Time
+
+	mu      sync.Mutex
+	last    *string
+	meta    map[string]string
+	pending []*pendingRequest
+
+	send      chan []byte
+	done      chan struct{}
+	closed    int32
+	quotaWarn int32
+	closeOnce sync.Once
+
+	// slot reports whether the connection holds a max connections slot.
+	slot bool
+	// escaped reports whether frames read from the peer are escaped
+	// WebSocket messages.
+	escaped bool
+}
+
+func newConnection(conn net.Conn, id string, queueSize int) *connection {
+	_, escaped := conn.(*wsConn)
+	return &connection{
+		Conn:        conn,
+		escaped:     escaped,
+		id:          id,
+		connectedAt: time.Now(),
+		send:        make(chan []byte, queueSize),
+		done:        make(chan struct{}),
+	}
+}
+
+// isClosed reports whether the connection has been closed by the bridge.
+func (c *connection) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+type peerReport struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	LocalAddr   string    `json:"localAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	BytesIn     uint64    `json:"bytesIn"`
+	BytesOut    uint64    `json:"bytesOut"`
+
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// report returns what the bridge knows about the peer. The connection does
+// not carry TLS, ALPN, or handshake state, so neither does the report.
+func (c *connection) report() peerReport {
+	c.mu.Lock()
+	var meta map[string]string
+	if len(c.meta) > 0 {
+		meta = make(map[string]string, len(c.meta))
+		for k, v := range c.meta {
+			meta[k] = v
+		}
+	}
+	c.mu.Unlock()
+	return peerReport{
+		ID:          c.id,
+		RemoteAddr:  c.RemoteAddr().String(),
+		LocalAddr:   c.LocalAddr().String(),
+		ConnectedAt: c.connectedAt,
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+		Meta:        meta,
+	}
+}
+
+// setMeta sets the metadata value of key, or deletes it when value is empty.
+// It fails when the metadata would exceed maxMetaEntries entries or when key
+// and value exceed maxMetaSize bytes.
+func (c *connection) setMeta(key, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value == "" {
+		delete(c.meta, key)
+		return nil
+	}
+	if len(key)+len(value) > maxMetaSize {
+		return errMetaTooLarge
+	}
+	if _, ok := c.meta[key]; !ok && len(c.meta) >= maxMetaEntries {
+		return errMetaFull
+	}
+	if c.meta == nil {
+		c.meta = make(map[string]string)
+	}
+	c.meta[key] = value
+	return nil
+}
+
+// getMeta returns the metadata value of key.
+func (c *connection) getMeta(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.meta[key]
+	return v, ok
+}
+
+// setLast retains text as the last frame, or forgets the last frame when
+// text is nil.
+func (c *connection) setLast(text *string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = text
+}
+
+// getLast returns the last frame retained.
+func (c *connection) getLast() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return "", false
+	}
+	return *c.last, true
+}
+
+// enqueue enqueues p to the send queue without blocking. It returns false
+// when the queue is full or the connection has been closed.
+func (c *connection) enqueue(p []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		return false
+	}
+	select {
+	case c.send <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// account counts bytes received from and sent to the peer. When the byte
+// quota is exceeded, it closes the connection and returns false.
+func (b *bridge) account(ctx context.Context, c *connection, in, out int) bool {
+	atomic.AddUint64(&b.totalIn, uint64(in))
+	atomic.AddUint64(&b.totalOut, uint64(out))
+	total := atomic.AddUint64(&c.bytesIn, uint64(in)) + atomic.AddUint64(&c.bytesOut, uint64(out))
+	if b.byteQuota == 0 {
+		return true
+	}
+	if total > b.byteQuota {
+		b.disconnect(ctx, c, "quota")
+		return false
+	}
+	// Warn at 90% of the quota, which is the quota itself for a small quota
+	if total >= b.byteQuota-b.byteQuota/10 && atomic.CompareAndSwapInt32(&c.quotaWarn, 0, 1) {
+		if err := b.emit(ctx, "%s:%s:%d:%d", prefixQuotaWarn, c.id, total, b.byteQuota); err != nil && ctx.Err() == nil {
+			log.Printf("warn: failed to write quota warning of %s: %s", c.id, err)
+		}
+	}
+	return true
+}
+
+// lookup returns the registered connection for id.
+func (b *bridge) lookup(id string) (*connection, bool) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	c, ok := b.connMap[id]
+	return c, ok
+}
+
+// connections returns a snapshot of the registered connections.
+func (b *bridge) connections() []*connection {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	conns := make([]*connection, 0, len(b.connMap))
+	for _, c := range b.connMap {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// disconnect unregisters and closes the connection, then notifies the
+// disconnection with the reason (if any).
+//
+// It is safe to call it multiple times; only the first call takes effect so
+// exactly one disconnection is notified per connection.
+func (b *bridge) disconnect(ctx context.Context, c *connection, reason string) {
+	c.closeOnce.Do(func() {
+		// Mark closed while locked so that nothing is enqueued after
+		// handleSend drains the send queue.
+		c.mu.Lock()
+		atomic.StoreInt32(&c.closed, 1)
+		close(c.done)
+		c.mu.Unlock()
+		c.Close()
+		b.wakeInFlight()
+		// Notify before another connection can reuse the key, so that the
+		// editor never receives its 'c' line before this 'd' line (see
+		// serve).
+		b.dbatchMu.Lock()
+		b.connMu.Lock()
+		if b.connMap[c.id] == c {
+			delete(b.connMap, c.id)
+		}
+		b.connMu.Unlock()
+		if c.slot {
+			b.slots.release()
+		}
+		if !b.textSuppressed {
+			b.notifyDisconnect(ctx, c.id, reason)
+		}
+		b.dbatchMu.Unlock()
+		b.publish(ctx, Event{Type: EventDisconnect, ID: c.id, Addr: c.RemoteAddr(), Reason: reason})
+	})
+}
+
+type disconnection struct {
+	id     string
+	reason string
+}
+
+// notifyDisconnect notifies the disconnection immediately, or within the
+// disconnect batch window together with other disconnections. The caller
+// must lock dbatchMu.
+func (b *bridge) notifyDisconnect(ctx context.Context, id, reason string) {
+	if b.disconnectBatch <= 0 {
+		b.emitDisconnect(ctx, disconnection{id, reason})
+		return
+	}
+	b.dbatch = append(b.dbatch, disconnection{id, reason})
+	if len(b.dbatch) == 1 {
+		b.dbatchTimer = b.clock.AfterFunc(b.disconnectBatch, func() {
+			// The batch may outlive the context of the disconnection
+			b.flushDisconnects(context.Background())
+		})
+	}
+}
+
+// flushDisconnects notifies the disconnections batched so far.
+func (b *bridge) flushDisconnects(ctx context.Context) {
+	b.dbatchMu.Lock()
+	defer b.dbatchMu.Unlock()
+	b.emitBatch(ctx)
+}
+
+// emitBatch notifies the batched disconnections in a single 'dbatch' line,
+// or in a regular 'd' line when only one has been batched. The caller must
+// lock dbatchMu.
+func (b *bridge) emitBatch(ctx context.Context) {
+	batch := b.dbatch
+	b.dbatch = nil
+	if b.dbatchTimer != nil {
+		b.dbatchTimer.Stop()
+		b.dbatchTimer = nil
+	}
+	switch len(batch) {
+	case 0:
+		return
+	case 1:
+		b.emitDisconnect(ctx, batch[0])
+		return
+	}
+	ids := make([]string, len(batch))
+	for i, d := range batch {
+		ids[i] = d.id
+	}
+	err := b.emitLine(ctx, func() string {
+		for _, id := range ids {
+			delete(b.announced, id)
+		}
+		return fmt.Sprintf("%s:%s", prefixDisconnectBatch, strings.Join(ids, ","))
+	})
+	if err != nil && ctx.Err() == nil && !isClosedPipe(err) {
+		log.Printf("warn: failed to write disconnections from %s: %s", strings.Join(ids, ","), err)
+	}
+}
+
+func (b *bridge) emitDisconnect(ctx context.Context, d disconnection) {
+	err := b.emitLine(ctx, func() string {
+		delete(b.announced, d.id)
+		if d.reason == "" {
+			return fmt.Sprintf("%s:%s", prefixDisconnect, d.id)
+		}
+		return fmt.Sprintf("%s:%s:%s", prefixDisconnect, d.id, d.reason)
+	})
+	if err != nil && ctx.Err() == nil && !isClosedPipe(err) {
+		log.Printf("warn: failed to write disconnection from %s: %s", d.id, err)
+	}
+}
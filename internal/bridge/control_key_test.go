@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lambdalisue/gi.bridge/internal/pkg/cryptio"
+)
+
+var testControlKey = []byte("0123456789abcdef0123456789abcdef")
+
+// keyedBridge drives a bridge with the control channel key as the editor.
+type keyedBridge struct {
+	inW   *io.PipeWriter
+	in    io.Writer
+	sent  bytes.Buffer
+	lines chan string
+	addr  string
+	done  chan error
+}
+
+func startKeyed(t *testing.T) *keyedBridge {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	b := New(inR, outW, WithControlChannelKey(testControlKey))
+	k := &keyedBridge{inW: inW, lines: make(chan string, 64), done: make(chan error, 1)}
+	go func() {
+		k.done <- b.Start(context.Background(), "127.0.0.1:0")
+		outW.Close()
+	}()
+
+	// The session precedes the sealed lines
+	var sealed bytes.Buffer
+	r := bufio.NewReader(io.TeeReader(outR, &sealed))
+	session, err := cryptio.ReadSession(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cryptio.Reader(r, testControlKey, controlKeyLabelOut, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go readLines(out, k.lines)
+	k.addr = strings.TrimPrefix(nextLine(t, k.lines), "a:")
+	if strings.Contains(sealed.String(), k.addr) {
+		t.Errorf("the address is written in plain text: %q", sealed.String())
+	}
+	if k.in, err = cryptio.Writer(io.MultiWriter(inW, &k.sent), testControlKey, controlKeyLabelIn, session); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// wait waits for Start to return and fails unless it returns an error.
+func (k *keyedBridge) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case err := <-k.done:
+		if err == nil {
+			t.Error("Start returned no error for the replayed line")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for Start to return")
+	}
+}
+
+func TestControlChannelKey(t *testing.T) {
+	k := startKeyed(t)
+
+	// Lines read from the editor must be sealed
+	if _, err := k.in.Write([]byte("!addr\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextLine(t, k.lines); got != "a:"+k.addr {
+		t.Errorf("got %q, want %q", got, "a:"+k.addr)
+	}
+
+	// Replaying a line stops the bridge
+	if _, err := k.inW.Write(k.sent.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	k.wait(t)
+}
+
+func TestControlChannelKeyReplayedSession(t *testing.T) {
+	first := startKeyed(t)
+	if _, err := first.in.Write([]byte("!addr\n")); err != nil {
+		t.Fatal(err)
+	}
+	nextLine(t, first.lines)
+	first.inW.Close()
+
+	// A stream recorded in a session does not open in another session
+	second := startKeyed(t)
+	if _, err := second.inW.Write(first.sent.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	second.wait(t)
+}
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestControlOverConn drives the bridge over an in-memory connection which
+// stands in for a TCP control connection.
+func TestControlOverConn(t *testing.T) {
+	editor, control := net.Pipe()
+	defer editor.Close()
+	b := New(control, control)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Start(context.Background(), "127.0.0.1:0")
+	}()
+	lines := make(chan string, 64)
+	go readLines(editor, lines)
+	addr := strings.TrimPrefix(nextLine(t, lines), "a:")
+
+	write := func(line string) {
+		t.Helper()
+		_ = editor.SetWriteDeadline(time.Now().Add(testTimeout))
+		if _, err := editor.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("!addr")
+	if got := nextLine(t, lines); got != "a:"+addr {
+		t.Fatalf("got %q, want %q", got, "a:"+addr)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	id := localPort(conn)
+	if got := nextLine(t, lines); got != "c:"+id {
+		t.Fatalf("got %q, want %q", got, "c:"+id)
+	}
+	write(id + ":hello")
+	if got := readPeer(t, conn); got != "hello\n" {
+		t.Errorf("the peer got %q, want %q", got, "hello\n")
+	}
+	if _, err := conn.Write([]byte("world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextLine(t, lines); got != "r:"+id+":world" {
+		t.Errorf("got %q, want %q", got, "r:"+id+":world")
+	}
+
+	// Losing the control connection shuts the bridge down
+	editor.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start returned an error: %s", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for Start to return")
+	}
+	assertClosed(t, conn)
+}
+
+// TestPeerReset asserts that a peer which resets its connection is
+// disconnected without shutting the bridge down.
+func TestPeerReset(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	connA, idA := h.dial()
+	defer connA.Close()
+	connB, idB := h.dial()
+
+	// Closing with no linger sends RST instead of FIN
+	if err := connB.(*net.TCPConn).SetLinger(0); err != nil {
+		t.Fatal(err)
+	}
+	connB.Close()
+	h.expect(prefixDisconnect + delim + idB)
+
+	h.send("%s:hello", idA)
+	if got, want := readPeer(t, connA), "hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
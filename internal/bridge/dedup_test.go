@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDedupFrames(t *testing.T) {
+	h := start(t, WithDedupFrames(100))
+	defer h.close()
+	conn, id := h.dial()
+
+	if _, err := conn.Write([]byte(strings.Repeat("a\n", 5) + "b\n" + "a\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":a")
+	h.expect("rrep:" + id + ":4")
+	h.expect("r:" + id + ":b")
+	h.expect("r:" + id + ":a")
+	// The count is reported only when something is repeated
+	if _, err := conn.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	h.expect("rrep:" + id + ":1")
+	h.expect("d:" + id)
+}
+
+func TestDedupFramesWindow(t *testing.T) {
+	h := start(t, WithDedupFrames(3))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Repeat("a\n", 8) + "b\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":a")
+	h.expect("rrep:" + id + ":3")
+	h.expect("rrep:" + id + ":3")
+	h.expect("rrep:" + id + ":1")
+	h.expect("r:" + id + ":b")
+}
+
+func TestDedupFramesDisabled(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("a\na\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":a")
+	h.expect("r:" + id + ":a")
+}
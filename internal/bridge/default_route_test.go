@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+)
+
+// gatewayKey names the first connection "gw" and the others by number.
+func gatewayKey(info ConnInfo) string {
+	if info.Seq == 1 {
+		return "gw"
+	}
+	return fmt.Sprintf("conn-%d", info.Seq)
+}
+
+func TestDefaultRoute(t *testing.T) {
+	h := start(t, WithDefaultRoute("gw"), WithConnKeyFormat(gatewayKey))
+	defer h.close()
+	gw := h.dialRaw()
+	defer gw.Close()
+	h.expect("c:gw")
+	other := h.dialRaw()
+	defer other.Close()
+	h.expect("c:conn-2")
+
+	h.send("999:hello")
+	if got, want := readPeer(t, gw), "999:hello\n"; got != want {
+		t.Errorf("got %q on the default connection, want %q", got, want)
+	}
+	// Data addressed to known connections are not rerouted
+	h.send("gw:direct")
+	if got, want := readPeer(t, gw), "direct\n"; got != want {
+		t.Errorf("got %q on the default connection, want %q", got, want)
+	}
+	h.send("conn-2:hi")
+	if got, want := readPeer(t, other), "hi\n"; got != want {
+		t.Errorf("got %q on the other connection, want %q", got, want)
+	}
+}
+
+func TestDefaultRouteAbsent(t *testing.T) {
+	h := start(t, WithDefaultRoute("gw"), WithErrorRing(4))
+	defer h.close()
+
+	h.send("999:hello")
+	ids, messages := h.dump()
+	if len(ids) != 1 || ids[0] != "999" || messages[0] != "no connection exists for 999" {
+		t.Errorf("got records %q of %q, want the unknown port error of 999", messages, ids)
+	}
+}
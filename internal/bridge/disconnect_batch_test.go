@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitUnregistered waits until the connection of id is unregistered.
+func waitUnregistered(t *testing.T, b *bridge, id string) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		if _, ok := b.lookup(id); !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is still registered", id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDisconnectBatch(t *testing.T) {
+	h := start(t, WithDisconnectBatch(500*time.Millisecond))
+	defer h.close()
+
+	var conns []net.Conn
+	var ids []string
+	for i := 0; i < 5; i++ {
+		conn, id := h.dial()
+		conns = append(conns, conn)
+		ids = append(ids, id)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	line := h.expectPrefix("dbatch:")
+	got := strings.Split(strings.TrimPrefix(line, "dbatch:"), ",")
+	sort.Strings(got)
+	sort.Strings(ids)
+	if strings.Join(got, ",") != strings.Join(ids, ",") {
+		t.Errorf("got %q, want %q", got, ids)
+	}
+}
+
+func TestDisconnectBatchSingle(t *testing.T) {
+	clock := newFakeClock()
+	h := start(t, WithClock(clock), WithDisconnectBatch(time.Second))
+	defer h.close()
+
+	conn, id := h.dial()
+	conn.Close()
+	clock.waitTimers(t, 1)
+	h.quiet(50 * time.Millisecond)
+	clock.Advance(time.Second)
+	h.expect("d:" + id)
+}
+
+func TestDisconnectBatchBeforeConnect(t *testing.T) {
+	h := start(t, WithDisconnectBatch(time.Hour), WithSingleConnection(SingleConnectionReplace))
+	defer h.close()
+
+	first, old := h.dial()
+	defer first.Close()
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("d:" + old + ":replaced")
+	h.expect("c:" + localPort(second))
+}
+
+func TestDisconnectBatchReusedKey(t *testing.T) {
+	h := start(t, WithDisconnectBatch(time.Hour), WithConnKeyFormat(func(ConnInfo) string {
+		return "same"
+	}))
+	defer h.close()
+
+	first := h.dialRaw()
+	h.expect("c:same")
+	first.Close()
+	waitUnregistered(t, h.b, "same")
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("d:same")
+	h.expect("c:same")
+}
+
+func TestDisconnectBatchShutdown(t *testing.T) {
+	h := start(t, WithDisconnectBatch(time.Hour))
+	defer h.close()
+
+	conn, id := h.dial()
+	conn.Close()
+	waitUnregistered(t, h.b, id)
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+	h.expect("d:" + id)
+	h.expectPrefix("shutdown-summary:")
+}
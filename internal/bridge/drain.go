@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"context"
+	"log"
+)
+
+// bufferedLine is a line read from 'in' and whether another line is already
+// buffered after it.
+type bufferedLine struct {
+	data string
+	more bool
+}
+
+// drain handles the lines from 'in' which are already buffered once the
+// shutdown begins, and waits for the data to be sent to peers, within the
+// shutdown timeout. Lines which are still in the pipe are not read since
+// reading them may block.
+func (b *bridge) drain(ctx context.Context) {
+	timeout := b.clock.After(shutdownTimeout)
+	close(b.draining)
+	select {
+	case <-b.drained:
+	case <-timeout:
+		log.Printf("warn: timed out handling the lines buffered at the shutdown")
+		return
+	case <-ctx.Done():
+		return
+	}
+	if !b.waitSent(timeout) {
+		log.Printf("warn: timed out sending the data queued at the shutdown")
+	}
+}
@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holdWriter is an out which holds writing the first line with prefix once
+// armed, until release is closed.
+type holdWriter struct {
+	io.WriteCloser
+	prefix  string
+	mu      sync.Mutex
+	armed   bool
+	held    chan struct{}
+	release chan struct{}
+}
+
+func (w *holdWriter) arm() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.armed = true
+}
+
+func (w *holdWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	hold := w.armed && strings.HasPrefix(string(p), w.prefix)
+	if hold {
+		w.armed = false
+	}
+	w.mu.Unlock()
+	if hold {
+		close(w.held)
+		<-w.release
+	}
+	return w.WriteCloser.Write(p)
+}
+
+func TestDrainIncoming(t *testing.T) {
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	outR, outW := io.Pipe()
+	out := &holdWriter{
+		WriteCloser: outW,
+		prefix:      prefixAddress + delim,
+		held:        make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	b := New(inR, out, WithDrainIncoming(true))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Start(ctx, "127.0.0.1:0")
+		outW.Close()
+	}()
+	lines := make(chan string, 64)
+	go readLines(outR, lines)
+	h := &harness{t: t, b: b, lines: lines}
+	h.addr = strings.TrimPrefix(h.expectPrefix(prefixAddress+delim), prefixAddress+delim)
+	conn, id := h.dial()
+	defer conn.Close()
+
+	// The final send is buffered while '!addr' is being handled
+	out.arm()
+	if _, err := io.WriteString(inW, "!addr\n"+id+":bye\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-out.held:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for '!addr' to be handled")
+	}
+	cancel()
+	close(out.release)
+	if got, want := readPeer(t, conn), "bye\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for {
+		line := h.next()
+		if strings.HasPrefix(line, prefixError+delim) {
+			t.Errorf("got %q, want no rejection", line)
+		}
+		if strings.HasPrefix(line, prefixShutdownSummary+delim) {
+			break
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start returned an error: %s", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for Start to return")
+	}
+}
@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFullDuplexFlood floods a peer which never reads while it floods the
+// bridge, and asserts that the bridge keeps handling both directions.
+func TestFullDuplexFlood(t *testing.T) {
+	h := start(t, WithSendQueueSize(4))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+
+	// The peer floods without reading
+	frame := []byte(strings.Repeat("x", 1023) + "\n")
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := conn.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The editor floods the peer at the same time
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		payload := strings.Repeat("y", 64<<10)
+		for i := 0; i < 200; i++ {
+			if _, err := h.in.Write([]byte(id + ":" + payload + "\n")); err != nil {
+				return
+			}
+		}
+		h.in.Write([]byte("!addr\n"))
+	}()
+
+	deadline := time.After(testTimeout)
+	received := 0
+	for {
+		select {
+		case line := <-h.lines:
+			if line == "a:"+h.addr {
+				if received == 0 {
+					t.Error("got no frame from the peer")
+				}
+				select {
+				case <-sent:
+				case <-deadline:
+					t.Fatal("timed out sending to the peer")
+				}
+				return
+			}
+			if strings.HasPrefix(line, "r:"+id+":") {
+				received++
+			}
+		case <-deadline:
+			t.Fatalf("the bridge stalls after %d frames from the peer", received)
+		}
+	}
+}
+
+// TestQueueFull floods a peer which never reads and asserts that the editor
+// is told about the dropped data.
+func TestQueueFull(t *testing.T) {
+	h := start(t, WithSendQueueSize(1))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	go func() {
+		payload := strings.Repeat("y", 64<<10)
+		for i := 0; i < 1000; i++ {
+			if _, err := h.in.Write([]byte(id + ":" + payload + "\n")); err != nil {
+				return
+			}
+		}
+	}()
+	h.until(prefixError + delim + id + delim + "queue-full")
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Encoding is an encoding of payloads exchanged with the editor.
+type Encoding string
+
+const (
+	// EncodingRaw exchanges payloads as is. Surrounding whitespaces are
+	// trimmed as a payload must fit in a line.
+	EncodingRaw Encoding = "raw"
+	// EncodingBase64 exchanges payloads in standard base64 so that any bytes
+	// (including newlines) can be represented.
+	EncodingBase64 Encoding = "base64"
+)
+
+// encode encodes a frame received from a peer.
+func (e Encoding) encode(frame string) string {
+	switch e {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString([]byte(strings.TrimSuffix(frame, "\n")))
+	default:
+		return strings.TrimSpace(frame)
+	}
+}
+
+// decode decodes an expression sent from the editor.
+func (e Encoding) decode(expr string) ([]byte, error) {
+	switch e {
+	case EncodingBase64:
+		p, err := base64.StdEncoding.DecodeString(expr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64: %w", err)
+		}
+		return p, nil
+	default:
+		return []byte(expr), nil
+	}
+}
@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncodingSwitch(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	if _, err := conn.Write([]byte("raw\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":raw")
+
+	h.send("!encoding:base64")
+	h.expect("encoding:base64")
+	// Both directions use the new encoding after the acknowledgement
+	if _, err := conn.Write([]byte("a b\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":" + base64.StdEncoding.EncodeToString([]byte("a b")))
+	h.send("%s:%s", id, base64.StdEncoding.EncodeToString([]byte("c d")))
+	if got := readPeer(t, conn); got != "c d\n" {
+		t.Errorf("the peer got %q, want %q", got, "c d\n")
+	}
+
+	h.send("!encoding:unknown")
+	h.send("!encoding:raw")
+	h.expect("encoding:raw")
+	if _, err := conn.Write([]byte("raw\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":raw")
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func TestEncodingPerDirection(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	tests := []struct {
+		inbound  Encoding
+		outbound Encoding
+		// send is sent by the editor and the peer receives sent
+		send string
+		sent string
+		// frame is sent by the peer and the editor receives received
+		frame    string
+		received string
+	}{
+		{EncodingRaw, EncodingRaw, "hello", "hello\n", "world\n", "world"},
+		{EncodingBase64, EncodingRaw, b64([]byte("\x00\xff")), "\x00\xff\n", "world\n", "world"},
+		{EncodingRaw, EncodingBase64, "hello", "hello\n", "\x00\xff\n", b64([]byte("\x00\xff"))},
+		{EncodingBase64, EncodingBase64, b64([]byte("\x00\xff")), "\x00\xff\n", "\x00\xff\n", b64([]byte("\x00\xff"))},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("%s-%s", tt.inbound, tt.outbound), func(t *testing.T) {
+			h := start(t, WithInboundEncoding(tt.inbound), WithOutboundEncoding(tt.outbound))
+			defer h.close()
+
+			conn, id := h.dial()
+			defer conn.Close()
+			h.send("%s:%s", id, tt.send)
+			if got := readPeer(t, conn); got != tt.sent {
+				t.Errorf("the peer got %q, want %q", got, tt.sent)
+			}
+			if _, err := conn.Write([]byte(tt.frame)); err != nil {
+				t.Fatal(err)
+			}
+			h.expect("r:" + id + ":" + tt.received)
+		})
+	}
+}
+
+func TestEncodingInvalidBase64(t *testing.T) {
+	h := start(t, WithInboundEncoding(EncodingBase64))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	h.send("%s:not base64", id)
+	h.send("%s:%s", id, base64.StdEncoding.EncodeToString([]byte("hello")))
+	if got, want := readPeer(t, conn), "hello\n"; got != want {
+		t.Errorf("the peer got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dump sends '!errors' and returns the ids and messages of the records.
+func (h *harness) dump() (ids, messages []string) {
+	h.t.Helper()
+	h.send("!errors")
+	line := h.expectPrefix(prefixErrors + delim)
+	n, err := strconv.Atoi(strings.TrimPrefix(line, prefixErrors+delim))
+	if err != nil {
+		h.t.Fatalf("failed to parse %q: %s", line, err)
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for i := 0; i < n; i++ {
+		line := h.expectPrefix(prefixErrorRecord + delim)
+		m := strings.SplitN(line, delim, 4)
+		if len(m) != 4 {
+			h.t.Fatalf("got %q, want err:<unix ms>:<id>:<message>", line)
+		}
+		ms, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || ms > now || now-ms > int64(testTimeout/time.Millisecond) {
+			h.t.Errorf("got time %q in %q, want around %d", m[1], line, now)
+		}
+		ids = append(ids, m[2])
+		messages = append(messages, m[3])
+	}
+	return ids, messages
+}
+
+func TestErrorRing(t *testing.T) {
+	h := start(t,
+		WithErrorRing(8),
+		WithInboundEncoding(EncodingBase64),
+		WithConnKeyFormat(func(ConnInfo) string { return "same" }),
+	)
+	defer h.close()
+	first := h.dialRaw()
+	defer first.Close()
+	h.expect("c:same")
+
+	h.send("999:c2VjcmV0")
+	h.send("same:!secret!")
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("reject:" + second.LocalAddr().String() + ":duplicate")
+
+	ids, messages := h.dump()
+	wantIDs := []string{"999", "same", "same"}
+	wantMessages := []string{"no connection exists for 999", "failed to decode 8 bytes of data to same", "rejected " + second.LocalAddr().String() + ": duplicate"}
+	if len(ids) != len(wantIDs) {
+		t.Fatalf("got %d records %q, want %d", len(ids), messages, len(wantIDs))
+	}
+	for i := range ids {
+		if ids[i] != wantIDs[i] {
+			t.Errorf("got id %q, want %q", ids[i], wantIDs[i])
+		}
+		if !strings.HasPrefix(messages[i], wantMessages[i]) {
+			t.Errorf("got message %q, want %q", messages[i], wantMessages[i])
+		}
+		if strings.Contains(messages[i], "secret") {
+			t.Errorf("the message %q has the payload", messages[i])
+		}
+	}
+}
+
+func TestErrorRingBounded(t *testing.T) {
+	h := start(t, WithErrorRing(2))
+	defer h.close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		h.send("%s:hello", id)
+	}
+	ids, _ := h.dump()
+	if got, want := strings.Join(ids, ","), "2,3"; got != want {
+		t.Errorf("got ids %q, want %q", got, want)
+	}
+}
+
+func TestErrorRingDisabled(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("1:hello")
+	if ids, _ := h.dump(); len(ids) != 0 {
+		t.Errorf("got records of %q, want none", ids)
+	}
+}
+
+func TestErrorRingEscapesLineBreaks(t *testing.T) {
+	h := start(t, WithErrorRing(2))
+	defer h.close()
+
+	h.b.recordError("1", "first\r\nsecond")
+	_, messages := h.dump()
+	if len(messages) != 1 || messages[0] != `first\r\nsecond` {
+		t.Errorf("got messages %q, want %q", messages, `first\r\nsecond`)
+	}
+}
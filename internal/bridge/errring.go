@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type errorRecord struct {
+	time    time.Time
+	id      string
+	message string
+}
+
+// errorRing retains the last errors up to its size.
+type errorRing struct {
+	mu      sync.Mutex
+	records []errorRecord
+	next    int
+	full    bool
+}
+
+func newErrorRing(size int) *errorRing {
+	return &errorRing{records: make([]errorRecord, size)}
+}
+
+func (r *errorRing) record(id, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[r.next] = errorRecord{time.Now(), id, message}
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the retained errors from the oldest.
+func (r *errorRing) snapshot() []errorRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]errorRecord(nil), r.records[:r.next]...)
+	}
+	return append(append([]errorRecord(nil), r.records[r.next:]...), r.records[:r.next]...)
+}
+
+// recordError records the error of id when the error ring is enabled. Line
+// breaks in message are escaped so that a record never spans lines.
+func (b *bridge) recordError(id, message string) {
+	if b.errRing != nil {
+		b.errRing.record(id, lineBreakEscaper.Replace(message))
+	}
+}
+
+var lineBreakEscaper = strings.NewReplacer("\r", "\\r", "\n", "\\n")
+
+// dumpErrors emits 'errors:<count>' followed by 'err:<unix ms>:<id>:<message>'
+// lines of the retained errors from the oldest. The id is empty when the
+// error is not tied to a valid connection id.
+func (b *bridge) dumpErrors(ctx context.Context) error {
+	var records []errorRecord
+	if b.errRing != nil {
+		records = b.errRing.snapshot()
+	}
+	if err := b.emit(ctx, "%s:%d", prefixErrors, len(records)); err != nil {
+		return err
+	}
+	for _, r := range records {
+		ms := r.time.UnixNano() / int64(time.Millisecond)
+		if err := b.emit(ctx, "%s:%d:%s:%s", prefixErrorRecord, ms, r.id, r.message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType is a type of Event.
+type EventType int
+
+const (
+	// EventConnect is published when a connection is admitted.
+	EventConnect EventType = iota + 1
+	// EventDisconnect is published when a connection is closed.
+	EventDisconnect
+	// EventReceive is published when a frame is received from a peer.
+	EventReceive
+	// EventError is published when sending data to a peer fails.
+	EventError
+)
+
+// Event is a typed protocol event for Go embedders.
+type Event struct {
+	Type EventType
+	// ID is the key of the connection.
+	ID string
+	// Addr is the remote address of the connection. It is nil for Error
+	// events of unknown connections.
+	Addr net.Addr
+	// Payload is the frame received (without the trailing newline) for
+	// Receive events.
+	Payload []byte
+	// Reason is the reason of Disconnect events (if any).
+	Reason string
+	// Err is the error of Error events.
+	Err error
+}
+
+// EventPolicy decides what to do when the event channel is full.
+type EventPolicy string
+
+const (
+	// EventDrop drops the event, so that a slow consumer never blocks the
+	// bridge.
+	EventDrop EventPolicy = "drop"
+	// EventBlock blocks the publisher until the event is consumed, so that
+	// no event is lost. A consumer which stops consuming stalls the bridge.
+	EventBlock EventPolicy = "block"
+)
+
+type events struct {
+	mu     sync.RWMutex
+	ch     chan Event
+	policy EventPolicy
+	closed bool
+}
+
+// Events returns a channel of protocol events, or nil unless WithEvents is
+// given. The channel is closed when Start returns.
+func (b *bridge) Events() <-chan Event {
+	if b.events == nil {
+		return nil
+	}
+	return b.events.ch
+}
+
+func (b *bridge) publish(ctx context.Context, e Event) {
+	if b.events == nil {
+		return
+	}
+	b.events.mu.RLock()
+	defer b.events.mu.RUnlock()
+	if b.events.closed {
+		return
+	}
+	if b.events.policy == EventBlock {
+		select {
+		case b.events.ch <- e:
+		case <-ctx.Done():
+		}
+		return
+	}
+	select {
+	case b.events.ch <- e:
+	default:
+		log.Printf("debug: drop an event of %s as the event channel is full", e.ID)
+	}
+}
+
+func (b *bridge) closeEvents() {
+	if b.events == nil {
+		return
+	}
+	b.events.mu.Lock()
+	defer b.events.mu.Unlock()
+	if !b.events.closed {
+		b.events.closed = true
+		close(b.events.ch)
+	}
+}
+
+// fail logs and records the failure of sending data to the peer of id and
+// publishes it as an Error event.
+func (b *bridge) fail(ctx context.Context, c *connection, id string, err error) {
+	log.Printf("warn: %s", err)
+	atomic.AddUint64(&b.errorCount, 1)
+	b.recordError(id, err.Error())
+	e := Event{Type: EventError, ID: id, Err: err}
+	if c != nil {
+		e.Addr = c.RemoteAddr()
+	}
+	b.publish(ctx, e)
+}
@@ -0,0 +1,84 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event published.
+func nextEvent(t *testing.T, b *bridge) Event {
+	t.Helper()
+	select {
+	case e, ok := <-b.Events():
+		if !ok {
+			t.Fatal("the event channel has been closed")
+		}
+		return e
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}
+}
+
+func TestEvents(t *testing.T) {
+	h := start(t, WithEvents(16, EventDrop))
+	defer h.close()
+
+	conn, id := h.dial()
+	if e := nextEvent(t, h.b); e.Type != EventConnect || e.ID != id || e.Addr.String() != conn.LocalAddr().String() {
+		t.Errorf("got %+v, want a Connect event of %s", e, id)
+	}
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":hello")
+	if e := nextEvent(t, h.b); e.Type != EventReceive || e.ID != id || string(e.Payload) != "hello" {
+		t.Errorf("got %+v, want a Receive event of hello", e)
+	}
+	h.send("99:hello")
+	if e := nextEvent(t, h.b); e.Type != EventError || e.ID != "99" || e.Err == nil {
+		t.Errorf("got %+v, want an Error event of 99", e)
+	}
+	conn.Close()
+	h.expect("d:" + id)
+	if e := nextEvent(t, h.b); e.Type != EventDisconnect || e.ID != id || e.Reason != "" {
+		t.Errorf("got %+v, want a Disconnect event of %s", e, id)
+	}
+
+	if err := h.stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-h.b.Events(); ok {
+		t.Error("the event channel is not closed after Start returns")
+	}
+}
+
+func TestEventsTextSuppressed(t *testing.T) {
+	h := start(t, WithEvents(16, EventDrop), WithTextSuppressed(true))
+	defer h.close()
+
+	conn := h.dialRaw()
+	if e := nextEvent(t, h.b); e.Type != EventConnect {
+		t.Errorf("got %+v, want a Connect event", e)
+	}
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, h.b); e.Type != EventReceive {
+		t.Errorf("got %+v, want a Receive event", e)
+	}
+	conn.Close()
+	if e := nextEvent(t, h.b); e.Type != EventDisconnect {
+		t.Errorf("got %+v, want a Disconnect event", e)
+	}
+	// Neither 'c', 'r', nor 'd' lines are written
+	h.send("!addr")
+	h.expect("a:" + h.addr)
+}
+
+func TestEventsDisabled(t *testing.T) {
+	b := New(nil, nil)
+	if b.Events() != nil {
+		t.Error("got an event channel without WithEvents")
+	}
+}
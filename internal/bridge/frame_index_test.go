@@ -0,0 +1,27 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFrameIndex(t *testing.T) {
+	h := start(t, WithFrameIndex(true))
+	defer h.close()
+
+	first, a := h.dial()
+	defer first.Close()
+	second, b := h.dial()
+	defer second.Close()
+	for i := 1; i <= 3; i++ {
+		if _, err := fmt.Fprintf(first, "a%d\n", i); err != nil {
+			t.Fatal(err)
+		}
+		h.expect(fmt.Sprintf("r:%s:%d:a%d", a, i, i))
+	}
+	// The index is per connection
+	if _, err := fmt.Fprintf(second, "b\n"); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(fmt.Sprintf("r:%s:1:b", b))
+}
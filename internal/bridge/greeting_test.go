@@ -0,0 +1,20 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestGreeting(t *testing.T) {
+	h := start(t, WithGreeting([]byte("hello")))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	h.send("%s:world", id)
+	if got := readPeer(t, conn); got != "hello\n" {
+		t.Errorf("got %q first, want the greeting", got)
+	}
+	if got := readPeer(t, conn); got != "world\n" {
+		t.Errorf("got %q, want %q", got, "world\n")
+	}
+}
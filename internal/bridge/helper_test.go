@@ -0,0 +1,209 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// harness drives a bridge over pipes standing in for the editor's stdin and
+// stdout.
+type harness struct {
+	t      testing.TB
+	b      *bridge
+	in     *io.PipeWriter
+	lines  chan string
+	done   chan error
+	cancel context.CancelFunc
+	addr   string
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// start starts a bridge on a random local port and waits for the address.
+func start(t testing.TB, opts ...Option) *harness {
+	t.Helper()
+	h := startOn(t, "127.0.0.1:0", opts...)
+	h.addr = strings.TrimPrefix(h.expectPrefix(prefixAddress+delim), prefixAddress+delim)
+	return h
+}
+
+// startOn starts a bridge on addr without waiting for anything.
+func startOn(t testing.TB, addr string, opts ...Option) *harness {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &harness{
+		t:      t,
+		b:      New(inR, outW, opts...),
+		in:     inW,
+		lines:  make(chan string, 4096),
+		done:   make(chan error, 1),
+		cancel: cancel,
+	}
+	go func() {
+		h.done <- h.b.Start(ctx, addr)
+		outW.Close()
+	}()
+	go readLines(outR, h.lines)
+	return h
+}
+
+// readLines sends lines read from r to lines until r is closed.
+func readLines(r io.Reader, lines chan<- string) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			close(lines)
+			return
+		}
+		lines <- strings.TrimSuffix(line, "\n")
+	}
+}
+
+// next returns the next line written to out.
+func (h *harness) next() string {
+	h.t.Helper()
+	return nextLine(h.t, h.lines)
+}
+
+func nextLine(t testing.TB, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			t.Fatal("out has been closed")
+		}
+		return line
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a line")
+	}
+	return ""
+}
+
+// expect fails unless the next line is want.
+func (h *harness) expect(want string) {
+	h.t.Helper()
+	if got := h.next(); got != want {
+		h.t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// expectPrefix fails unless the next line starts with prefix and returns it.
+func (h *harness) expectPrefix(prefix string) string {
+	h.t.Helper()
+	got := h.next()
+	if !strings.HasPrefix(got, prefix) {
+		h.t.Fatalf("got %q, want a line starting with %q", got, prefix)
+	}
+	return got
+}
+
+// until skips lines until one starts with prefix and returns it.
+func (h *harness) until(prefix string) string {
+	h.t.Helper()
+	for {
+		if line := h.next(); strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+}
+
+// quiet fails when a line is written to out within d.
+func (h *harness) quiet(d time.Duration) {
+	h.t.Helper()
+	select {
+	case line, ok := <-h.lines:
+		if ok {
+			h.t.Fatalf("got %q, want no line", line)
+		}
+	case <-time.After(d):
+	}
+}
+
+// send writes a line to in.
+func (h *harness) send(format string, a ...interface{}) {
+	h.t.Helper()
+	if _, err := fmt.Fprintf(h.in, format+"\n", a...); err != nil {
+		h.t.Fatalf("failed to write to in: %s", err)
+	}
+}
+
+// dial connects a peer to the bridge and waits for its 'c' line. It returns
+// the connection and its id, the local port by default.
+func (h *harness) dial() (net.Conn, string) {
+	h.t.Helper()
+	conn := h.dialRaw()
+	id := localPort(conn)
+	h.expect(prefixConnect + delim + id)
+	return conn, id
+}
+
+// localPort returns the local port of conn, which is the id of the peer.
+func localPort(conn net.Conn) string {
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return port
+}
+
+// dialRaw connects a peer to the bridge without waiting for anything.
+func (h *harness) dialRaw() net.Conn {
+	h.t.Helper()
+	conn, err := net.DialTimeout("tcp", h.addr, testTimeout)
+	if err != nil {
+		h.t.Fatalf("failed to dial %s: %s", h.addr, err)
+	}
+	return conn
+}
+
+// stop closes in as the editor does on exit and returns what Start returned.
+func (h *harness) stop() error {
+	h.t.Helper()
+	h.stopOnce.Do(func() {
+		h.in.Close()
+		select {
+		case h.stopErr = <-h.done:
+		case <-time.After(testTimeout):
+			h.cancel()
+			h.t.Fatal("timed out waiting for Start to return")
+		}
+	})
+	return h.stopErr
+}
+
+// close stops the bridge unless it has been stopped; defer it after start.
+func (h *harness) close() {
+	h.t.Helper()
+	if err := h.stop(); err != nil {
+		h.t.Errorf("Start returned an error: %s", err)
+	}
+	h.cancel()
+}
+
+// readPeer reads a line written to the peer. It reads byte by byte so that
+// nothing after the line is consumed.
+func readPeer(t testing.TB, conn net.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			t.Fatalf("failed to read from the peer after %q: %s", line, err)
+		}
+		line = append(line, b[0])
+		if b[0] == '\n' {
+			return string(line)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+//go:build !windows
+// +build !windows
+
+package bridge
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const idlePeersEnv = "GI_BRIDGE_IDLE_PEERS"
+
+var idleConns = flag.Int("idle-conns", 10000, "the number of connections BenchmarkIdleConnections opens")
+
+// BenchmarkIdleConnections measures the memory and CPU which the bridge
+// spends on idle connections. The peers are dialed from a child process so
+// that neither their file descriptors nor their memory count against the
+// bridge. Each iteration idles for a second.
+//
+//	go test -run - -bench IdleConnections -benchtime 10x ./internal/bridge
+func BenchmarkIdleConnections(b *testing.B) {
+	n := *idleConns
+	raiseFileLimit(b, uint64(n)+64)
+	h := start(b)
+	defer h.close()
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	rssBefore := maxRSS(b)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestIdlePeers$")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s,%d", idlePeersEnv, h.addr, n))
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		// The peers exit once stdin is closed
+		stdin.Close()
+		go func() {
+			for range h.lines {
+			}
+		}()
+		if err := cmd.Wait(); err != nil {
+			b.Errorf("the peers failed: %s", err)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		h.expectPrefix(prefixConnect + delim)
+	}
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	rssAfter := maxRSS(b)
+
+	b.ResetTimer()
+	cpuBefore := cpuTime(b)
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(time.Second)
+	}
+	cpu := cpuTime(b) - cpuBefore
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	heap := (after.HeapInuse + after.StackInuse) - (before.HeapInuse + before.StackInuse)
+	b.ReportMetric(float64(heap)/float64(n), "heap-B/conn")
+	b.ReportMetric(float64(rssAfter-rssBefore)/float64(n), "rss-B/conn")
+	b.ReportMetric(100*cpu.Seconds()/elapsed.Seconds(), "cpu-%")
+	b.ReportMetric(float64(runtime.NumGoroutine())/float64(n), "goroutines/conn")
+}
+
+// TestIdlePeers is not a test but the child process of
+// BenchmarkIdleConnections, which dials the idle peers and keeps them open
+// until stdin is closed.
+func TestIdlePeers(t *testing.T) {
+	env := os.Getenv(idlePeersEnv)
+	if env == "" {
+		return
+	}
+	m := strings.SplitN(env, ",", 2)
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		t.Fatalf("failed to parse %s=%s: %s", idlePeersEnv, env, err)
+	}
+	raiseFileLimit(t, uint64(n)+64)
+	conns := make([]net.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		conn, err := net.DialTimeout("tcp", m[0], time.Minute)
+		if err != nil {
+			t.Fatalf("failed to dial %s after %d connections: %s", m[0], i, err)
+		}
+		conns = append(conns, conn)
+	}
+	_, _ = io.Copy(ioutil.Discard, os.Stdin)
+}
+
+// raiseFileLimit raises the soft limit of file descriptors to n, or skips
+// when the hard limit is lower.
+func raiseFileLimit(tb testing.TB, n uint64) {
+	tb.Helper()
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		tb.Fatal(err)
+	}
+	if lim.Cur >= n {
+		return
+	}
+	if lim.Max < n {
+		tb.Skipf("the file descriptor limit %d is lower than %d", lim.Max, n)
+	}
+	lim.Cur = n
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		tb.Skipf("failed to raise the file descriptor limit to %d: %s", n, err)
+	}
+}
+
+// maxRSS returns the maximum resident set size of the process in bytes.
+func maxRSS(tb testing.TB) int64 {
+	tb.Helper()
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		tb.Fatal(err)
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+	// Kilobytes on Linux and BSD
+	return int64(ru.Maxrss) * 1024
+}
+
+// cpuTime returns the user and system CPU time of the process.
+func cpuTime(tb testing.TB) time.Duration {
+	tb.Helper()
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		tb.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
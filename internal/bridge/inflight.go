@@ -0,0 +1,84 @@
+package bridge
+
+import (
+	"context"
+	"time"
+)
+
+// acquire counts data which is about to be enqueued to the send queue of the
+// connection. When the maximum number of data is in flight across all
+// connections, it blocks until the number falls to the half of the maximum,
+// so that handleIncoming stops reading 'in' and the editor is backpressured.
+// It returns false without counting when the connection is closed or ctx is
+// done while waiting.
+func (b *bridge) acquire(ctx context.Context, c *connection) bool {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+	if b.maxInFlight > 0 && b.inflight >= b.maxInFlight {
+		for {
+			// Check even after the number falls since the wake up may be
+			// caused by the closing connection draining its queue.
+			if ctx.Err() != nil || c.isClosed() {
+				return false
+			}
+			if b.inflight <= b.maxInFlight/2 {
+				break
+			}
+			b.inflightCond.Wait()
+		}
+	}
+	b.inflight++
+	return true
+}
+
+// release uncounts data which has been written to the peer or dropped.
+func (b *bridge) release() {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+	b.inflight--
+	if b.inflight <= b.maxInFlight/2 {
+		b.inflightCond.Broadcast()
+	}
+}
+
+// wakeInFlight wakes acquire up to notice that a connection has been closed
+// or the context is done.
+func (b *bridge) wakeInFlight() {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+	b.inflightCond.Broadcast()
+}
+
+// InFlight returns the number of data queued to be sent to peers.
+func (b *bridge) InFlight() int {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+	return b.inflight
+}
+
+// waitSent waits until no data is in flight. It returns false when timeout
+// fires first.
+func (b *bridge) waitSent(timeout <-chan time.Time) bool {
+	expired := false
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-timeout:
+			b.inflightMu.Lock()
+			expired = true
+			b.inflightCond.Broadcast()
+			b.inflightMu.Unlock()
+		case <-stop:
+		}
+	}()
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+	for b.inflight > 0 {
+		if expired {
+			return false
+		}
+		b.inflightCond.Wait()
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package bridge
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testInFlight     = 4
+	testInFlightSize = 1 << 20
+	testInFlightData = 32
+)
+
+// flood sends data larger than the socket buffers to ids in turn in the
+// background and returns the number of lines the bridge has read so far.
+func (h *harness) flood(ids ...string) (*int32, chan struct{}) {
+	var sent int32
+	done := make(chan struct{})
+	payload := strings.Repeat("x", testInFlightSize)
+	go func() {
+		defer close(done)
+		for i := 0; i < testInFlightData; i++ {
+			id := ids[i%len(ids)]
+			if _, err := io.WriteString(h.in, id+delim+payload+"\n"); err != nil {
+				return
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+	}()
+	return &sent, done
+}
+
+// waitBackpressure waits until the bridge stops reading in, while the peer
+// does not read, and checks that the cap is never exceeded.
+func waitBackpressure(t *testing.T, b *bridge, sent *int32) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	last, stable := int32(-1), 0
+	for stable < 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the bridge kept reading in: %d lines", atomic.LoadInt32(sent))
+		}
+		if n := b.InFlight(); n > testInFlight {
+			t.Fatalf("got %d data in flight, want at most %d", n, testInFlight)
+		}
+		if n := atomic.LoadInt32(sent); n == last {
+			stable++
+		} else {
+			last, stable = n, 0
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if last >= testInFlightData {
+		t.Fatalf("the bridge read all %d lines", last)
+	}
+	if n := b.InFlight(); n != testInFlight {
+		t.Fatalf("got %d data in flight, want %d", n, testInFlight)
+	}
+}
+
+func TestMaxInFlightBackpressuresUntilPeerDrains(t *testing.T) {
+	h := start(t, WithMaxInFlight(testInFlight))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	sent, done := h.flood(id)
+	waitBackpressure(t, h.b, sent)
+
+	read := make(chan int64, 1)
+	go func() {
+		n, _ := io.CopyN(ioutil.Discard, conn, testInFlightData*(testInFlightSize+1))
+		read <- n
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out sending data: %d lines", atomic.LoadInt32(sent))
+	}
+	select {
+	case n := <-read:
+		if want := int64(testInFlightData * (testInFlightSize + 1)); n != want {
+			t.Fatalf("the peer read %d bytes, want %d", n, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out reading data on the peer")
+	}
+	deadline := time.Now().Add(testTimeout)
+	for h.b.InFlight() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d data in flight, want 0", h.b.InFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMaxInFlightAcrossConnections(t *testing.T) {
+	h := start(t, WithMaxInFlight(testInFlight))
+	defer h.close()
+	connA, idA := h.dial()
+	connB, idB := h.dial()
+
+	// The cap is shared by the connections
+	sent, done := h.flood(idA, idB)
+	waitBackpressure(t, h.b, sent)
+
+	connA.Close()
+	connB.Close()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out sending data after the disconnection: %d lines", atomic.LoadInt32(sent))
+	}
+}
+
+func TestMaxInFlightClamped(t *testing.T) {
+	b := New(nil, nil, WithSendQueueSize(2), WithMaxInFlight(testInFlight))
+	if got, want := b.maxInFlight, 2; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestMaxInFlightStopsWaitingOnDisconnection(t *testing.T) {
+	h := start(t, WithMaxInFlight(testInFlight))
+	defer h.close()
+	conn, id := h.dial()
+
+	sent, done := h.flood(id)
+	waitBackpressure(t, h.b, sent)
+
+	conn.Close()
+	h.until(prefixDisconnect + delim + id)
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out sending data after the disconnection: %d lines", atomic.LoadInt32(sent))
+	}
+	h.send("!addr")
+	h.until(prefixAddress + delim)
+}
+
+func TestMaxInFlightStopsWaitingOnShutdown(t *testing.T) {
+	h := start(t, WithMaxInFlight(testInFlight))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	sent, _ := h.flood(id)
+	waitBackpressure(t, h.b, sent)
+
+	h.cancel()
+	h.until(prefixError + delim + id + delim + "shutting-down")
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+}
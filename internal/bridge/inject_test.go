@@ -0,0 +1,47 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInject(t *testing.T) {
+	h := start(t, WithTestInject(true))
+	defer h.close()
+
+	h.send("!inject-connect:42")
+	h.expect("c:42")
+	h.send("!inject-recv:42:hello: world")
+	h.expect("r:42:hello: world")
+	h.send("!inject-disconnect:42")
+	h.expect("d:42")
+}
+
+func TestInjectInvalidID(t *testing.T) {
+	h := start(t, WithTestInject(true))
+	defer h.close()
+
+	for _, line := range []string{
+		"!inject-connect:",
+		"!inject-connect:4:2",
+		"!inject-recv::hello",
+		"!inject-recv:42",
+		"!inject-disconnect:",
+		"!inject-disconnect:4:2",
+	} {
+		h.send(line)
+	}
+	// Nothing is emitted for the invalid ids
+	h.send("!addr")
+	h.expect("a:" + h.addr)
+}
+
+func TestInjectDisabled(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("!inject-connect:42")
+	h.send("!inject-recv:42:hello")
+	h.send("!inject-disconnect:42")
+	h.quiet(100 * time.Millisecond)
+}
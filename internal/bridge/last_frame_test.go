@@ -0,0 +1,42 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLastFrame(t *testing.T) {
+	h := start(t, WithLastFrameCache(8))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	h.send("!last:%s", id)
+	h.expect("last:" + id)
+
+	for _, frame := range []string{"first", "second"} {
+		if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		h.expect("r:" + id + ":" + frame)
+	}
+	h.send("!last:%s", id)
+	h.expect("last:" + id + ":second")
+
+	// A frame larger than the size is not retained
+	large := strings.Repeat("x", 9)
+	if _, err := conn.Write([]byte(large + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":" + large)
+	h.send("!last:%s", id)
+	h.expect("last:" + id)
+}
+
+func TestLastFrameUnknown(t *testing.T) {
+	h := start(t, WithLastFrameCache(8))
+	defer h.close()
+
+	h.send("!last:12345")
+	h.expect(prefixError + delim + "12345" + delim + "unknown")
+}
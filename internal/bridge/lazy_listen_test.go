@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address which nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestLazyListen(t *testing.T) {
+	addr := freeAddr(t)
+	h := startOn(t, addr, WithLazyListen(true))
+	defer h.close()
+	h.addr = addr
+
+	h.quiet(100 * time.Millisecond)
+	if conn, err := net.DialTimeout("tcp", addr, testTimeout); err == nil {
+		conn.Close()
+		t.Fatalf("%s is bound before '!listen'", addr)
+	}
+
+	h.send("!listen")
+	h.expect(prefixAddress + delim + addr)
+	conn, _ := h.dial()
+	defer conn.Close()
+	// The address is notified again once bound
+	h.send("!listen")
+	h.expect(prefixAddress + delim + addr)
+}
+
+func TestLazyListenRetry(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+	addr := occupied.Addr().String()
+	h := startOn(t, addr, WithLazyListen(true))
+	defer h.close()
+	h.addr = addr
+
+	h.send("!listen")
+	line := h.expectPrefix(prefixAddress + delim + "error" + delim)
+	if !strings.Contains(line, addr) {
+		t.Errorf("got %q, want the reason with %s", line, addr)
+	}
+	select {
+	case err := <-h.done:
+		t.Fatalf("Start returned on the failure: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	occupied.Close()
+	h.send("!listen")
+	h.expect(prefixAddress + delim + addr)
+	conn, _ := h.dial()
+	defer conn.Close()
+}
+
+func TestLazyListenShutdownBeforeListen(t *testing.T) {
+	h := startOn(t, freeAddr(t), WithLazyListen(true))
+	defer h.close()
+
+	h.cancel()
+	h.expect(prefixShutdownSummary + delim + "0:0")
+}
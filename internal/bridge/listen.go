@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	schemeTCP  = "tcp://"
+	schemeUnix = "unix://"
+	schemePipe = "npipe://"
+)
+
+// listen listens on addr which is one of 'host:port' or 'tcp://host:port'
+// for TCP, 'unix://path' for a Unix domain socket, 'npipe://./pipe/name' for
+// a Windows named pipe, and 'ws://host:port/path' for WebSocket. A Unix
+// domain socket is created with socketMode unless it is zero.
+func listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, schemeUnix):
+		return listenUnix(strings.TrimPrefix(addr, schemeUnix), socketMode)
+	case strings.HasPrefix(addr, schemeWebSocket):
+		return listenWebSocket(addr)
+	case strings.HasPrefix(addr, schemePipe):
+		// npipe://./pipe/name -> \\.\pipe\name
+		path := strings.TrimPrefix(addr, schemePipe)
+		return listenPipe(`\\` + strings.Replace(path, "/", `\`, -1))
+	default:
+		return net.Listen("tcp", strings.TrimPrefix(addr, schemeTCP))
+	}
+}
+
+// FileListener returns a listener on the listening socket of the file
+// descriptor fd (e.g. inherited from the parent process). fd is closed since
+// the listener holds a duplicate of it.
+func FileListener(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, fmt.Sprintf("fd %d", fd))
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen fd %d: %w", fd, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("invalid listen fd %d: not a socket (%s)", fd, fi.Mode())
+	}
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on fd %d: %w", fd, err)
+	}
+	return l, nil
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// dupFd returns a duplicate of the file descriptor of f, which the caller
+// owns unlike f.Fd().
+func dupFd(t *testing.T, f *os.File) uintptr {
+	t.Helper()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uintptr(fd)
+}
+
+func TestFileListener(t *testing.T) {
+	pre, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pre.Close()
+	f, err := pre.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := dupFd(t, f)
+	f.Close()
+
+	l, err := FileListener(fd)
+	if err != nil {
+		t.Fatalf("FileListener failed: %s", err)
+	}
+	// The address given to Start is not used
+	h := startOn(t, "127.0.0.1:1", WithListener(l))
+	defer h.close()
+	h.expect(prefixAddress + delim + pre.Addr().String())
+	h.addr = pre.Addr().String()
+	pre.Close()
+	conn, _ := h.dial()
+	defer conn.Close()
+}
+
+func TestFileListenerInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "gi-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tests := []struct {
+		name string
+		fd   func(t *testing.T) uintptr
+		want string
+	}{
+		{"closed", func(t *testing.T) uintptr {
+			// Beyond any file descriptor limit so that it is never open
+			return 1 << 30
+		}, "invalid listen fd"},
+		{"file", func(t *testing.T) uintptr {
+			return dupFd(t, f)
+		}, "not a socket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FileListener(tt.fd(t))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+var umaskMu sync.Mutex
+
+func listenPipe(path string) (net.Listener, error) {
+	return nil, fmt.Errorf("named pipe %s is not supported on this platform", path)
+}
+
+// listenUnix listens on a Unix domain socket at path. The umask is changed
+// while binding so that the socket has the mode before any client could
+// connect.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		return net.Listen("unix", path)
+	}
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(int(^mode.Perm() & os.ModePerm))
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package bridge
+
+import (
+	"net"
+	"os"
+
+	"github.com/Microsoft/go-winio"
+)
+
+func listenPipe(path string) (net.Listener, error) {
+	return winio.ListenPipe(path, nil)
+}
+
+// listenUnix listens on a Unix domain socket at path. The mode is ignored as
+// Windows does not control access to a Unix domain socket by its file mode.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	h.send("!getmeta:%s:user", id)
+	h.expect("meta:" + id + ":user")
+	h.send("!setmeta:%s:user:alice", id)
+	h.expect("meta:" + id + ":user:alice")
+	h.send("!getmeta:%s:user", id)
+	h.expect("meta:" + id + ":user:alice")
+
+	// Overwrite with a value which has ':'
+	h.send("!setmeta:%s:user:bob:admin", id)
+	h.expect("meta:" + id + ":user:bob:admin")
+	h.send("!getmeta:%s:user", id)
+	h.expect("meta:" + id + ":user:bob:admin")
+
+	// Delete
+	h.send("!setmeta:%s:user:", id)
+	h.expect("meta:" + id + ":user")
+	h.send("!getmeta:%s:user", id)
+	h.expect("meta:" + id + ":user")
+
+	h.send("!getmeta:12345:user")
+	h.expect("e:12345:unknown")
+	h.send("!setmeta:12345:user:alice")
+	h.expect("e:12345:unknown")
+}
+
+func TestMetaBounds(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	for i := 0; i < maxMetaEntries; i++ {
+		h.send("!setmeta:%s:k%d:v", id, i)
+		h.expect(fmt.Sprintf("meta:%s:k%d:v", id, i))
+	}
+	h.send("!setmeta:%s:extra:v", id)
+	h.expect("e:" + id + ":meta-full")
+	// Existing entries can still be overwritten
+	h.send("!setmeta:%s:k0:w", id)
+	h.expect("meta:" + id + ":k0:w")
+
+	h.send("!setmeta:%s:k1:%s", id, strings.Repeat("v", maxMetaSize))
+	h.expect("e:" + id + ":meta-too-large")
+	h.send("!getmeta:%s:k1", id)
+	h.expect("meta:" + id + ":k1:v")
+}
+
+func TestMetaClearedOnDisconnect(t *testing.T) {
+	h := start(t, WithConnKeyFormat(func(ConnInfo) string { return "same" }))
+	defer h.close()
+
+	first := h.dialRaw()
+	h.expect("c:same")
+	h.send("!setmeta:same:user:alice")
+	h.expect("meta:same:user:alice")
+	first.Close()
+	h.expect("d:same")
+	h.send("!getmeta:same:user")
+	h.expect("e:same:unknown")
+
+	// A new connection with the same id starts without metadata
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("c:same")
+	h.send("!getmeta:same:user")
+	h.expect("meta:same:user")
+}
@@ -0,0 +1,56 @@
+//go:build linux
+// +build linux
+
+package bridge
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// noDelay returns TCP_NODELAY of the connection of id on the bridge side.
+func noDelay(t *testing.T, b *bridge, id string) int {
+	t.Helper()
+	c, ok := b.lookup(id)
+	if !ok {
+		t.Fatalf("no connection exists for %s", id)
+	}
+	raw, err := c.Conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+func TestTCPNoDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want bool
+	}{
+		{"default", nil, true},
+		{"disabled", []Option{WithTCPNoDelay(false)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := start(t, tt.opts...)
+			defer h.close()
+			conn, id := h.dial()
+			defer conn.Close()
+			if got := noDelay(t, h.b, id) != 0; got != tt.want {
+				t.Errorf("got TCP_NODELAY %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
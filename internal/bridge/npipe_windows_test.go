@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+func TestNamedPipe(t *testing.T) {
+	name := fmt.Sprintf("gi-bridge-test-%d", os.Getpid())
+	path := `\\.\pipe\` + name
+	h := startOn(t, "npipe://./pipe/"+name)
+	defer h.close()
+	h.expect(prefixAddress + delim + path)
+
+	timeout := testTimeout
+	conn, err := winio.DialPipe(path, &timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// A named pipe has no port so the sequential number is the key
+	h.expect(prefixConnect + delim + "1")
+	h.send("1:hello")
+	if got, want := readPeer(t, conn), "hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := conn.Write([]byte("world\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + "1" + delim + "world")
+	conn.Close()
+	h.expect(prefixDisconnect + delim + "1")
+
+	// The pipe is closed on shutdown
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+	timeout = 100 * time.Millisecond
+	if conn, err := winio.DialPipe(path, &timeout); err == nil {
+		conn.Close()
+		t.Error("dialed the pipe after the shutdown")
+	}
+}
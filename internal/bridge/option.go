@@ -0,0 +1,359 @@
+package bridge
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// Option is a functional option which configures the bridge.
+type Option func(*bridge)
+
+// WithTestInject enables '!inject-*' control commands which synthesize
+// protocol events without real peers.
+//
+// It exists to let editor plugins test their parsers and must never be
+// enabled in production.
+func WithTestInject(enable bool) Option {
+	return func(b *bridge) {
+		b.testInject = enable
+	}
+}
+
+// WithWriteRetry sets how many times a write to out is retried when it fails
+// by a transient error. Non-transient errors (e.g. closed pipe) are never
+// retried.
+func WithWriteRetry(n int) Option {
+	return func(b *bridge) {
+		b.writeRetry = n
+	}
+}
+
+// SingleConnectionPolicy is a policy which decides what to do with a new
+// connection while another connection exists.
+type SingleConnectionPolicy string
+
+const (
+	// SingleConnectionNone allows any number of connections.
+	SingleConnectionNone SingleConnectionPolicy = ""
+	// SingleConnectionReject closes the new connection.
+	SingleConnectionReject SingleConnectionPolicy = "reject"
+	// SingleConnectionReplace closes the existing connection and admits the new one.
+	SingleConnectionReplace SingleConnectionPolicy = "replace"
+)
+
+// WithSingleConnection enforces that only one connection exists at a time.
+func WithSingleConnection(policy SingleConnectionPolicy) Option {
+	return func(b *bridge) {
+		b.single = policy
+	}
+}
+
+// WithConnKeyFormat sets a function which renders the key of a connection.
+// The key identifies the connection in all protocol lines and must be unique
+// among existing connections; a connection with a duplicate key is rejected,
+// and so is a connection with an empty key or a key which contains ':' or a
+// newline.
+//
+// The default renders the bare remote port.
+func WithConnKeyFormat(fn func(ConnInfo) string) Option {
+	return func(b *bridge) {
+		b.connKeyFormat = fn
+		b.customConnKey = true
+	}
+}
+
+// WithPortValidation rejects data from 'in' addressed to a non-numeric port
+// with 'e:<port>:invalid-port'. It is ignored with WithConnKeyFormat since a
+// custom key is not necessarily numeric.
+func WithPortValidation(enable bool) Option {
+	return func(b *bridge) {
+		b.validatePort = enable
+	}
+}
+
+// WithReadyFile sets a path of a file which is created once the bridge is
+// serving and removed when the bridge stops, so that a liveness probe can
+// check the presence of the file.
+func WithReadyFile(path string) Option {
+	return func(b *bridge) {
+		b.readyFile = path
+	}
+}
+
+// WithInboundEncoding sets the encoding of expressions sent from the editor.
+// Expressions are decoded before being written to peers.
+func WithInboundEncoding(e Encoding) Option {
+	return func(b *bridge) {
+		b.inboundEncoding = e
+	}
+}
+
+// WithOutboundEncoding sets the encoding of frames sent to the editor.
+// Frames received from peers are encoded before being written in 'r' lines.
+func WithOutboundEncoding(e Encoding) Option {
+	return func(b *bridge) {
+		b.outboundEncoding = e
+	}
+}
+
+// WithSendQueueSize sets the number of data which can be queued to be sent
+// to each peer. Data sent while the queue is full are dropped with
+// 'e:<id>:queue-full'.
+func WithSendQueueSize(n int) Option {
+	return func(b *bridge) {
+		b.sendQueueSize = n
+	}
+}
+
+// WithSendNewline sets whether a newline is appended to data sent to peers
+// so that line-oriented peers receive terminated lines. Data which already
+// ends with a newline is sent as is. The default is true.
+func WithSendNewline(enable bool) Option {
+	return func(b *bridge) {
+		b.sendNewline = enable
+	}
+}
+
+// WithSlowThreshold notifies blocking operations (writes to peers and to
+// out) which take longer than d by 'slow:<op>:<id>:<ms>' lines, where op is
+// 'write' to a peer of id or 'flush' to out (id is 'out'), and counts them in
+// 'stats' lines. Zero disables it.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(b *bridge) {
+		b.slowThreshold = d
+	}
+}
+
+// WithFrameIndex tags each 'r' line with a per-connection index which
+// starts from 1 and increases monotonically (r:<id>:<index>:<payload>), so
+// that the editor can detect missing frames.
+func WithFrameIndex(enable bool) Option {
+	return func(b *bridge) {
+		b.frameIndex = enable
+	}
+}
+
+// WithTCPNoDelay sets TCP_NODELAY of accepted TCP connections. The default
+// is true to send each frame immediately, which suits interactive use.
+// Disable it for bulk transfers of many small frames, where Nagle's algorithm
+// improves throughput by coalescing them at the cost of latency.
+func WithTCPNoDelay(enable bool) Option {
+	return func(b *bridge) {
+		b.tcpNoDelay = enable
+	}
+}
+
+// WithControlChannelKey encrypts the control channel ('in' and 'out') with
+// a pre-shared key. The bridge writes a new session to 'out' first, and each
+// line is sealed in the session as described in package cryptio; lines from
+// the editor use the label "gi.bridge editor to bridge" and lines to the
+// editor use "gi.bridge bridge to editor". A nil key disables it.
+func WithControlChannelKey(psk []byte) Option {
+	return func(b *bridge) {
+		b.controlKey = psk
+	}
+}
+
+// WithSocketMode sets the file mode of a Unix domain socket to listen on, so
+// that only intended users can connect. Zero leaves it to the umask.
+func WithSocketMode(mode os.FileMode) Option {
+	return func(b *bridge) {
+		b.socketMode = mode
+	}
+}
+
+// WithGreeting sets data written to each peer right after the connection is
+// admitted and before any data sent from the editor, for protocols in which
+// the server speaks first. It is framed like other data sent to peers.
+func WithGreeting(greeting []byte) Option {
+	return func(b *bridge) {
+		b.greeting = greeting
+	}
+}
+
+// WithDisconnectBatch batches disconnections which occur within the window
+// into a single 'dbatch:<id1,id2,...>' line, which does not carry reasons.
+// A disconnection which is not batched with others is notified by a regular
+// 'd' line at the end of the window. The batch is notified earlier before a
+// 'c' line and when the bridge stops. Zero disables it.
+func WithDisconnectBatch(window time.Duration) Option {
+	return func(b *bridge) {
+		b.disconnectBatch = window
+	}
+}
+
+// WithStallTimeout warns by a 'warn:stdin-stalled:<ms>' line when handling
+// a single line from 'in' takes longer than d, and by a
+// 'warn:stdin-recovered:<ms>' line with the total duration when the line has
+// been handled. Zero disables it.
+func WithStallTimeout(d time.Duration) Option {
+	return func(b *bridge) {
+		b.stallTimeout = d
+	}
+}
+
+// WithLastFrameCache retains the last frame received from each connection
+// so that '!last:<id>' replays it by a 'last:<id>:<payload>' line. A frame
+// larger than maxSize (after encoding) is not retained and 'last:<id>' is
+// replied instead. Zero disables it.
+func WithLastFrameCache(maxSize int) Option {
+	return func(b *bridge) {
+		b.lastFrameSize = maxSize
+	}
+}
+
+// WithConnectionByteQuota closes a connection with 'd:<id>:quota' once it
+// has transferred more than n bytes in total (received and sent) over its
+// lifetime. 'quota-warn:<id>:<used>:<quota>' is emitted once when 90% of
+// the quota is used. Zero disables it.
+func WithConnectionByteQuota(n uint64) Option {
+	return func(b *bridge) {
+		b.byteQuota = n
+	}
+}
+
+// WithEvents publishes protocol events to the channel returned by Events,
+// which buffers up to size events and follows policy when it is full.
+func WithEvents(size int, policy EventPolicy) Option {
+	return func(b *bridge) {
+		b.events = &events{
+			ch:     make(chan Event, size),
+			policy: policy,
+		}
+	}
+}
+
+// WithDedupFrames collapses identical consecutive frames from a peer into a
+// single 'r' line followed by 'rrep:<id>:<count>' with the number of repeats.
+// The count is reported when a different frame arrives, the peer is gone, or
+// window repeats have been collapsed. Zero disables it.
+func WithDedupFrames(window int) Option {
+	return func(b *bridge) {
+		b.dedupWindow = window
+	}
+}
+
+// WithStreamHeader makes data from 'in' follow 'port:stream:expr' and
+// prepends header to the data sent to the peer, where '{stream}' in header is
+// replaced with the stream. Empty disables it.
+func WithStreamHeader(header string) Option {
+	return func(b *bridge) {
+		b.streamHeader = header
+	}
+}
+
+// WithStatsInterval writes a 'stats' line every interval. Zero disables it.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(b *bridge) {
+		b.statsInterval = interval
+	}
+}
+
+// WithMaxConnections caps the number of connections. A connection accepted
+// at the cap waits for another to close for up to the admission timeout (see
+// WithAdmissionTimeout), in the order of acceptance, and is notified with
+// 'admitted:<id>:<waitMs>' once admitted. Zero disables it.
+func WithMaxConnections(n int) Option {
+	return func(b *bridge) {
+		b.maxConnections = n
+		if n > 0 {
+			b.slots = newSlots(n)
+		}
+	}
+}
+
+// WithAdmissionTimeout is how long a connection accepted at the max
+// connections cap waits before it is rejected with 'timeout'. A connection
+// whose peer closes while waiting is rejected with 'closed'. Zero rejects it
+// immediately with 'full'.
+func WithAdmissionTimeout(timeout time.Duration) Option {
+	return func(b *bridge) {
+		b.admissionTimeout = timeout
+	}
+}
+
+// WithReplyTimeout sets how long '!request' waits for the reply before it
+// writes 'reply-timeout:<seq>'. The default is 10 seconds.
+func WithReplyTimeout(timeout time.Duration) Option {
+	return func(b *bridge) {
+		b.replyTimeout = timeout
+	}
+}
+
+// WithListener serves on l (e.g. a socket inherited from the parent process)
+// instead of listening on the address given to Start. l is closed when the
+// bridge stops. Nil disables it.
+func WithListener(l net.Listener) Option {
+	return func(b *bridge) {
+		b.inherited = l
+	}
+}
+
+// WithLazyListen defers listening until the editor sends '!listen', then the
+// bridge listens and writes the 'a' line, or 'a:error:<reason>' when it fails
+// to listen so that the editor can send '!listen' again.
+func WithLazyListen(enable bool) Option {
+	return func(b *bridge) {
+		b.lazyListen = enable
+	}
+}
+
+// WithDefaultRoute sends data addressed to an unknown port to the connection
+// id instead, prefixed with the intended port (port:data). Data is rejected as
+// usual when the connection id is not registered either.
+func WithDefaultRoute(id string) Option {
+	return func(b *bridge) {
+		b.defaultRoute = id
+	}
+}
+
+// WithTextSuppressed suppresses 'c', 'd', 'dbatch', and 'r' lines on out, for
+// Go embedders which consume the Events channel instead. Replies to
+// '!request' are still written and '!last' still returns the last frame.
+func WithTextSuppressed(enable bool) Option {
+	return func(b *bridge) {
+		b.textSuppressed = enable
+	}
+}
+
+// WithMaxInFlight caps the number of data queued to be sent across all
+// connections. Once the cap is reached, the bridge stops reading 'in' until
+// the number falls to the half of the cap, the connection which the data is
+// sent to is closed, or the bridge shuts down. The cap is clamped to the send
+// queue size so that a single connection never drops data before it is
+// reached. Zero disables it.
+func WithMaxInFlight(n int) Option {
+	return func(b *bridge) {
+		b.maxInFlight = n
+	}
+}
+
+// WithDrainIncoming handles the lines from 'in' which are already buffered
+// when the shutdown begins, and waits for them to be sent to peers, before
+// the connections are closed, so that a final send right before the shutdown
+// is delivered. It takes up to the shutdown timeout. Lines which are still in
+// the pipe are not read since reading them may block.
+func WithDrainIncoming(enable bool) Option {
+	return func(b *bridge) {
+		b.drainIncoming = enable
+	}
+}
+
+// WithErrorRing retains the last size errors (send failures and rejections)
+// so that '!errors' dumps them. Zero disables it.
+func WithErrorRing(size int) Option {
+	return func(b *bridge) {
+		if size > 0 {
+			b.errRing = newErrorRing(size)
+		}
+	}
+}
+
+// WithClock replaces the clock which the bridge measures durations, timeouts,
+// and intervals with. The default is the clock of the time package.
+func WithClock(clock Clock) Option {
+	return func(b *bridge) {
+		b.clock = clock
+	}
+}
@@ -0,0 +1,31 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestPauseAccept(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	existing, id := h.dial()
+	defer existing.Close()
+
+	h.send("!pause-accept")
+	h.expect("accept:paused")
+	refused := h.dialRaw()
+	defer refused.Close()
+	h.expect("reject:" + refused.LocalAddr().String() + ":paused")
+	assertClosed(t, refused)
+
+	// The existing connection is kept
+	h.send("%s:hello", id)
+	if got := readPeer(t, existing); got != "hello\n" {
+		t.Errorf("the peer got %q, want %q", got, "hello\n")
+	}
+
+	h.send("!resume-accept")
+	h.expect("accept:resumed")
+	admitted, _ := h.dial()
+	defer admitted.Close()
+}
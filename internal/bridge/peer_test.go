@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerReport(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+	connectedAt := time.Now()
+
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + id + delim + "hello")
+	h.send("!setmeta:%s:user:alice", id)
+	h.expectPrefix(prefixMeta + delim + id + delim)
+
+	h.send("!peer:%s", id)
+	line := h.expectPrefix(prefixPeer + delim + id + delim)
+	raw := strings.TrimPrefix(line, prefixPeer+delim+id+delim)
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		t.Fatalf("failed to unmarshal %q: %s", raw, err)
+	}
+	for _, key := range []string{"id", "remoteAddr", "localAddr", "connectedAt", "bytesIn", "bytesOut", "meta"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("the report %s does not have %q", raw, key)
+		}
+	}
+	// The bridge only serves plain connections
+	if _, ok := fields["tls"]; ok {
+		t.Errorf("the report %s has TLS state", raw)
+	}
+
+	var report peerReport
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.ID != id {
+		t.Errorf("got id %q, want %q", report.ID, id)
+	}
+	// The remote address reported is the address of the peer itself
+	if report.RemoteAddr != conn.LocalAddr().String() {
+		t.Errorf("got remote address %q, want %q", report.RemoteAddr, conn.LocalAddr())
+	}
+	if report.LocalAddr != conn.RemoteAddr().String() {
+		t.Errorf("got local address %q, want %q", report.LocalAddr, conn.RemoteAddr())
+	}
+	if d := connectedAt.Sub(report.ConnectedAt); d < 0 || d > testTimeout {
+		t.Errorf("got connect time %s, want around %s", report.ConnectedAt, connectedAt)
+	}
+	if report.BytesIn != uint64(len("hello\n")) {
+		t.Errorf("got %d bytes in, want %d", report.BytesIn, len("hello\n"))
+	}
+	if report.Meta["user"] != "alice" {
+		t.Errorf("got meta %v, want user=alice", report.Meta)
+	}
+}
+
+func TestPeerReportUnknown(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("!peer:12345")
+	h.expect(prefixError + delim + "12345" + delim + "unknown")
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnectionByteQuota(t *testing.T) {
+	h := start(t, WithConnectionByteQuota(100))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	// 10 bytes to the peer and 85 bytes from the peer
+	h.send("%s:%s", id, strings.Repeat("y", 9))
+	readPeer(t, conn)
+	frame := strings.Repeat("x", 84)
+	if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("quota-warn:" + id + ":95:100")
+	h.expect("r:" + id + ":" + frame)
+	if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("d:" + id + ":quota")
+	assertClosed(t, conn)
+}
+
+func TestConnectionByteQuotaSmall(t *testing.T) {
+	h := start(t, WithConnectionByteQuota(5))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	for _, frame := range []string{"ab", "c"} {
+		if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if frame == "c" {
+			h.expect("quota-warn:" + id + ":5:5")
+		}
+		h.expect("r:" + id + ":" + frame)
+	}
+	if _, err := conn.Write([]byte("d\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("d:" + id + ":quota")
+}
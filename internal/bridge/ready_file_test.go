@@ -0,0 +1,30 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gi-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ready")
+
+	h := startOn(t, "127.0.0.1:0", WithReadyFile(path))
+	defer h.close()
+	h.expectPrefix("a:")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("the ready file does not exist after 'a': %s", err)
+	}
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the ready file exists after shutdown: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lambdalisue/gi.bridge/internal/pkg/cryptio"
+)
+
+// Reattach replaces 'in' and 'out' of the running bridge while keeping
+// connections, for an editor which reattaches to the bridge with new pipes.
+// The address and every connection which the editor has been notified of are
+// written to the new 'out' as 'a' and 'c' lines so that the editor resyncs
+// its state, before any other line. With WithControlChannelKey, they are
+// sealed in a new session which is written first.
+//
+// 'in' and 'out' are replaced only once the lines have been written. When
+// writing them fails or takes longer than the reattach timeout, Reattach
+// returns an error and the bridge keeps the previous 'in' and 'out'.
+//
+// Lines from the previous 'in' are no longer handled once Reattach returns,
+// and its EOF no longer stops the bridge. Close it after Reattach returns.
+func (b *bridge) Reattach(in io.Reader, out io.Writer) error {
+	// The new control channel is encrypted in a new session
+	raw := out
+	var session []byte
+	if b.controlKey != nil {
+		var err error
+		if in, out, session, err = b.encryptControl(in, out); err != nil {
+			return err
+		}
+	}
+
+	// Connections being served or disconnected write their lines after
+	// the snapshot, since they wait for outMu.
+	b.outMu.Lock()
+	defer b.outMu.Unlock()
+	if err := b.writeSnapshot(raw, session, out); err != nil {
+		return err
+	}
+	b.out = out
+
+	b.inMu.Lock()
+	b.in = in
+	close(b.inSwapped)
+	b.inSwapped = make(chan struct{})
+	b.inMu.Unlock()
+	return nil
+}
+
+// writeSnapshot writes the session to raw unless it is nil, and the 'a' and
+// 'c' lines of the current state to out, within the reattach timeout. The
+// caller must lock outMu.
+func (b *bridge) writeSnapshot(raw io.Writer, session []byte, out io.Writer) error {
+	var buf bytes.Buffer
+	if l := b.getListener(); l != nil {
+		fmt.Fprintf(&buf, "%s:%s\n", prefixAddress, l.Addr())
+	}
+	conns := make([]*connection, 0, len(b.announced))
+	for _, c := range b.announced {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].connectedAt.Before(conns[j].connectedAt)
+	})
+	for _, c := range conns {
+		fmt.Fprintf(&buf, "%s:%s\n", prefixConnect, c.id)
+	}
+	if buf.Len() == 0 && session == nil {
+		return nil
+	}
+
+	// Writing cannot be interrupted when out has no write deadline, so the
+	// write is abandoned on timeout. out is not used in that case anyway.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		if session != nil {
+			if err := cryptio.WriteSession(raw, session); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- b.write(ctx, out, buf.Bytes())
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			return fmt.Errorf("failed to write the snapshot: %w", err)
+		}
+		return nil
+	case <-b.clock.After(reattachTimeout):
+		return fmt.Errorf("failed to write the snapshot: timed out after %s", reattachTimeout)
+	}
+}
+
+// input returns 'in' and a channel which is closed when Reattach replaces it.
+func (b *bridge) input() (io.Reader, <-chan struct{}) {
+	b.inMu.Lock()
+	defer b.inMu.Unlock()
+	return b.in, b.inSwapped
+}
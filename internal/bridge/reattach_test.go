@@ -0,0 +1,179 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lambdalisue/gi.bridge/internal/pkg/cryptio"
+)
+
+func TestReattach(t *testing.T) {
+	h := start(t)
+	connA, idA := h.dial()
+	defer connA.Close()
+	connB, idB := h.dial()
+	connB.Close()
+	h.expect(prefixDisconnect + delim + idB)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	lines := make(chan string, 4096)
+	go readLines(outR, lines)
+	defer func() {
+		// EOF of the previous 'in' no longer stops the bridge
+		inW.Close()
+		h.close()
+	}()
+	if err := h.b.Reattach(inR, outW); err != nil {
+		t.Fatalf("Reattach failed: %s", err)
+	}
+	if got, want := nextLine(t, lines), prefixAddress+delim+h.addr; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := nextLine(t, lines), prefixConnect+delim+idA; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A line from the previous 'in' is dropped
+	h.send("%s:stale", idA)
+	if _, err := io.WriteString(inW, idA+":fresh\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readPeer(t, connA), "fresh\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := io.WriteString(inW, "!addr\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nextLine(t, lines), prefixAddress+delim+h.addr; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	h.quiet(50 * time.Millisecond)
+
+	// Lines are written to the new 'out'
+	if _, err := connA.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nextLine(t, lines), prefixReceive+delim+idA+delim+"hello"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReattachSnapshotAnnounced(t *testing.T) {
+	b := New(nil, &bufferWriter{})
+	for _, id := range []string{"announced", "registered", "disconnected"} {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		c := newConnection(server, id, 1)
+		switch id {
+		case "announced":
+			b.connMap[id] = c
+			b.announced[id] = c
+		case "registered":
+			// Registered but its 'c' line has not been written yet
+			b.connMap[id] = c
+		case "disconnected":
+			// Unregistered but its 'd' line has not been written yet
+			b.announced[id] = c
+		}
+	}
+	out := &bufferWriter{}
+	if err := b.Reattach(bytes.NewReader(nil), out); err != nil {
+		t.Fatalf("Reattach failed: %s", err)
+	}
+	if got, want := out.String(), "c:announced\nc:disconnected\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// errWriter is an out which fails every write.
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken")
+}
+
+// blockWriter is an out which blocks every write until release is closed.
+type blockWriter struct {
+	release chan struct{}
+}
+
+func (w blockWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return 0, io.ErrClosedPipe
+}
+
+func TestReattachFailure(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, _ := h.dial()
+	defer conn.Close()
+
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	if err := h.b.Reattach(inR, errWriter{}); err == nil {
+		t.Fatal("Reattach succeeded, want an error")
+	}
+	// The previous 'in' and 'out' are kept
+	h.send("!addr")
+	h.expect(prefixAddress + delim + h.addr)
+}
+
+func TestReattachTimeout(t *testing.T) {
+	clock := newFakeClock()
+	h := start(t, WithClock(clock))
+	defer h.close()
+
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	out := blockWriter{release: make(chan struct{})}
+	defer close(out.release)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.b.Reattach(inR, out)
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(reattachTimeout)
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("Reattach succeeded, want an error")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for Reattach to return")
+	}
+	h.send("!addr")
+	h.expect(prefixAddress + delim + h.addr)
+}
+
+func TestReattachControlChannelKey(t *testing.T) {
+	b := New(nil, &bufferWriter{}, WithControlChannelKey(testControlKey))
+	b.announced["announced"] = newConnection(nil, "announced", 1)
+	out := &bufferWriter{}
+	if err := b.Reattach(bytes.NewReader(nil), out); err != nil {
+		t.Fatalf("Reattach failed: %s", err)
+	}
+	// The snapshot is sealed in a new session
+	r := bufio.NewReader(out)
+	session, err := cryptio.ReadSession(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := cryptio.Reader(r, testControlKey, controlKeyLabelOut, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(opened).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := line, "c:announced\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
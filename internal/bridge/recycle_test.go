@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecycle(t *testing.T) {
+	for _, command := range []string{"!recycle", "!recycle:10ms"} {
+		command := command
+		t.Run(command, func(t *testing.T) {
+			h := start(t)
+			defer h.close()
+
+			first, a := h.dial()
+			defer first.Close()
+			second, b := h.dial()
+			defer second.Close()
+
+			h.send(command)
+			got := []string{h.next(), h.next()}
+			want := []string{"d:" + a + ":recycle", "d:" + b + ":recycle"}
+			sort.Strings(got)
+			sort.Strings(want)
+			if got[0] != want[0] || got[1] != want[1] {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			for _, conn := range []net.Conn{first, second} {
+				assertClosed(t, conn)
+			}
+		})
+	}
+}
+
+func TestRecycleStaggered(t *testing.T) {
+	const interval = 10 * time.Second
+	clock := newFakeClock()
+	h := start(t, WithClock(clock))
+	defer h.close()
+
+	ids := map[string]net.Conn{}
+	for i := 0; i < 3; i++ {
+		conn, id := h.dial()
+		defer conn.Close()
+		ids[id] = conn
+	}
+	h.send("!recycle:%s", interval)
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			// The next connection is closed only after the interval
+			clock.waitTimers(t, 1)
+			clock.Advance(interval - time.Millisecond)
+			h.quiet(50 * time.Millisecond)
+			clock.Advance(time.Millisecond)
+		}
+		line := h.expectPrefix(prefixDisconnect + delim)
+		id := strings.TrimSuffix(strings.TrimPrefix(line, prefixDisconnect+delim), delim+"recycle")
+		conn, ok := ids[id]
+		if !ok {
+			t.Fatalf("got %q, want a recycled connection", line)
+		}
+		assertClosed(t, conn)
+		delete(ids, id)
+	}
+}
+
+// assertClosed fails unless the bridge has closed conn.
+func assertClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
@@ -0,0 +1,85 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	prefixReply        = "reply"
+	prefixReplyTimeout = "reply-timeout"
+
+	defaultReplyTimeout = 10 * time.Second
+)
+
+// pendingRequest is a request which waits for the next frame of the
+// connection as the reply.
+type pendingRequest struct {
+	seq   string
+	timer Timer
+}
+
+// request sends p to the connection and replies the next frame from it by a
+// 'reply:<seq>:<payload>' line, or 'reply-timeout:<seq>' when no frame
+// arrives within the reply timeout. Outstanding requests of a connection are
+// replied in FIFO order.
+func (b *bridge) request(ctx context.Context, seq string, c *connection, p []byte) {
+	r := &pendingRequest{seq: seq}
+	c.mu.Lock()
+	// Register before sending so that a quick reply is never missed
+	c.pending = append(c.pending, r)
+	r.timer = b.clock.AfterFunc(b.replyTimeout, func() {
+		if !c.removeRequest(r) {
+			return
+		}
+		if err := b.emit(ctx, "%s:%s", prefixReplyTimeout, seq); err != nil && ctx.Err() == nil {
+			log.Printf("warn: failed to write reply timeout of %s: %s", seq, err)
+		}
+	})
+	c.mu.Unlock()
+	if !b.acquire(ctx, c) {
+		if c.removeRequest(r) {
+			r.timer.Stop()
+		}
+		if ctx.Err() == nil {
+			b.fail(ctx, c, c.id, fmt.Errorf("failed to enqueue request %s to %s: the connection is closed", seq, c.id))
+		}
+		return
+	}
+	if !c.enqueue(b.frame(p)) {
+		b.release()
+		if c.removeRequest(r) {
+			r.timer.Stop()
+		}
+		b.fail(ctx, c, c.id, fmt.Errorf("failed to enqueue request %s to %s: the send queue is full or closed", seq, c.id))
+	}
+}
+
+// removeRequest removes r from the pending requests. It returns false when r
+// has already been removed.
+func (c *connection) removeRequest(r *pendingRequest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p == r {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// popRequest removes and returns the oldest pending request, if any.
+func (c *connection) popRequest() (*pendingRequest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return nil, false
+	}
+	r := c.pending[0]
+	c.pending = c.pending[1:]
+	r.timer.Stop()
+	return r, true
+}
@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+const testReplyTimeout = 10 * time.Second
+
+func TestRequestReply(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	h.send("!request:1:%s:ping", id)
+	if got, want := readPeer(t, conn), "ping\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if _, err := conn.Write([]byte("pong\nnext\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReply + delim + "1" + delim + "pong")
+	// Only the next frame is the reply
+	h.expect(prefixReceive + delim + id + delim + "next")
+
+	h.send("!request:2:12345:ping")
+	h.expect(prefixError + delim + "12345" + delim + "unknown")
+}
+
+func TestRequestOrder(t *testing.T) {
+	h := start(t)
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	h.send("!request:1:%s:first", id)
+	h.send("!request:2:%s:second", id)
+	readPeer(t, conn)
+	readPeer(t, conn)
+	if _, err := conn.Write([]byte("a\nb\nc\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReply + delim + "1" + delim + "a")
+	h.expect(prefixReply + delim + "2" + delim + "b")
+	h.expect(prefixReceive + delim + id + delim + "c")
+}
+
+func TestRequestTimeout(t *testing.T) {
+	clock := newFakeClock()
+	h := start(t, WithClock(clock), WithReplyTimeout(testReplyTimeout))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	h.send("!request:1:%s:ping", id)
+	readPeer(t, conn)
+	clock.waitTimers(t, 1)
+	clock.Advance(testReplyTimeout - time.Millisecond)
+	h.quiet(50 * time.Millisecond)
+	clock.Advance(time.Millisecond)
+	h.expect(prefixReplyTimeout + delim + "1")
+
+	// A frame after the timeout is not a reply
+	if _, err := conn.Write([]byte("late\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + id + delim + "late")
+}
+
+func TestRequestTextSuppressed(t *testing.T) {
+	h := start(t, WithEvents(16, EventDrop), WithTextSuppressed(true), WithLastFrameCache(64))
+	defer h.close()
+	conn := h.dialRaw()
+	defer conn.Close()
+	e := nextEvent(t, h.b)
+	if e.Type != EventConnect {
+		t.Fatalf("got %+v, want a Connect event", e)
+	}
+
+	h.send("!request:1:%s:ping", e.ID)
+	if got, want := readPeer(t, conn), "ping\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if _, err := conn.Write([]byte("pong\nframe\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReply + delim + "1" + delim + "pong")
+	// The frame is retained even though its 'r' line is suppressed
+	deadline := time.Now().Add(testTimeout)
+	for {
+		h.send("!last:%s", e.ID)
+		if h.expectPrefix(prefixLast+delim+e.ID) == prefixLast+delim+e.ID+delim+"frame" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the last frame")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSendNewline(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	h.send("%s:hello", id)
+	if got, want := readPeer(t, conn), "hello\n"; got != want {
+		t.Errorf("the peer got %q, want %q", got, want)
+	}
+}
+
+func TestSendNewlineDisabled(t *testing.T) {
+	h := start(t, WithSendNewline(false))
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	h.send("%s:hello", id)
+	h.send("%s:world", id)
+	want := "helloworld"
+	got := make([]byte, len(want))
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("the peer got %q, want %q", got, want)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	b := New(nil, nil)
+	p := make([]byte, 5, 16)
+	copy(p, "hello")
+	if got := string(b.frame(p)); got != "hello\n" {
+		t.Errorf("got %q, want %q", got, "hello\n")
+	}
+	if got := string(p[:6]); got != "hello\x00" {
+		t.Errorf("frame modified p to %q", got)
+	}
+	if got := string(b.frame([]byte("hello\n"))); got != "hello\n" {
+		t.Errorf("got %q, want %q", got, "hello\n")
+	}
+	b = New(nil, nil, WithSendNewline(false))
+	if got := string(b.frame([]byte("hello"))); got != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
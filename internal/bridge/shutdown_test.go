@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestShutdownSummary(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("!addr")
+	h.expect("a:" + h.addr)
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+	h.expect("shutdown-summary:0:0")
+}
+
+func TestShutdownSummaryQueuedData(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	// The peer never reads so that the data stays queued
+	conn, id := h.dial()
+	defer conn.Close()
+	payload := strings.Repeat("x", 1<<20)
+	for i := 0; i < 32; i++ {
+		h.send("%s:%s", id, payload)
+	}
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+	line := h.until("shutdown-summary:")
+	var unsent, unwritten int
+	if _, err := fmt.Sscanf(line, "shutdown-summary:%d:%d", &unsent, &unwritten); err != nil {
+		t.Fatalf("failed to parse %q: %s", line, err)
+	}
+	if unsent == 0 {
+		t.Errorf("got %q, want data dropped to the peer", line)
+	}
+	if unwritten != 0 {
+		t.Errorf("got %q, want no frame dropped to the editor", line)
+	}
+}
+
+func TestShutdownNotifiesDisconnections(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	conn, id := h.dial()
+	defer conn.Close()
+	// Cancel as a signal does
+	h.cancel()
+	h.expect("d:" + id + ":shutdown")
+	h.expect("shutdown-summary:0:0")
+	assertClosed(t, conn)
+}
@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// bufferWriter is an out which retains everything written.
+type bufferWriter struct {
+	bytes.Buffer
+}
+
+func (w *bufferWriter) Close() error {
+	return nil
+}
+
+func TestShuttingDown(t *testing.T) {
+	out := &bufferWriter{}
+	b := New(ioutil.NopCloser(strings.NewReader("")), out)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, line := range []string{"42:hello", "43:"} {
+		if err := b.handleLine(ctx, line); err != nil {
+			t.Fatalf("handleLine failed: %s", err)
+		}
+	}
+	if got, want := out.String(), "e:42:shutting-down\ne:43:shutting-down\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestShuttingDownOnShutdown shuts the bridge down while a send waits for a
+// stalled peer, and asserts that the send is rejected before the shutdown
+// summary.
+func TestShuttingDownOnShutdown(t *testing.T) {
+	h := start(t, WithMaxInFlight(testInFlight))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	sent, _ := h.flood(id)
+	waitBackpressure(t, h.b, sent)
+	h.cancel()
+	rejected := false
+	for {
+		line := h.next()
+		if line == prefixError+delim+id+delim+"shutting-down" {
+			rejected = true
+		}
+		if strings.HasPrefix(line, prefixShutdownSummary+delim) {
+			break
+		}
+	}
+	if !rejected {
+		t.Error("got the shutdown summary before the send is rejected")
+	}
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+}
@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// createFileAtomic creates an empty file at path atomically so that a reader
+// never observes a partially written file.
+func createFileAtomic(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// syncFile flushes path and the directory which contains it to the disk so
+// that the file survives a crash. The file is opened for writing since
+// Windows flushes only a file which is writable.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms (e.g. Windows) do not support syncing a directory
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,28 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestSingleConnectionReject(t *testing.T) {
+	h := start(t, WithSingleConnection(SingleConnectionReject))
+	defer h.close()
+
+	first, _ := h.dial()
+	defer first.Close()
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("reject:" + second.LocalAddr().String() + ":busy")
+}
+
+func TestSingleConnectionReplace(t *testing.T) {
+	h := start(t, WithSingleConnection(SingleConnectionReplace))
+	defer h.close()
+
+	first, old := h.dial()
+	defer first.Close()
+	second := h.dialRaw()
+	defer second.Close()
+	h.expect("d:" + old + ":replaced")
+	h.expect("c:" + localPort(second))
+}
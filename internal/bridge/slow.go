@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const prefixSlow = "slow"
+
+// observe counts the operation started at start and notifies it by a
+// 'slow:<op>:<id>:<ms>' line when it took longer than the slow threshold.
+func (b *bridge) observe(ctx context.Context, op, id string, start time.Time) {
+	if b.slowThreshold <= 0 {
+		return
+	}
+	d := b.clock.Now().Sub(start)
+	if d <= b.slowThreshold {
+		return
+	}
+	n := atomic.AddUint64(&b.slowCount, 1)
+	log.Printf("debug: slow: %s on %s took %s (%d slow operations)", op, id, d, n)
+	// Write without observing, or slow writes to out would notify themselves
+	// endlessly.
+	b.outMu.Lock()
+	err := b.write(ctx, b.out, []byte(fmt.Sprintf("%s:%s:%s:%d\n", prefixSlow, op, id, d/time.Millisecond)))
+	b.outMu.Unlock()
+	if err != nil && ctx.Err() == nil {
+		log.Printf("warn: failed to write slow operation of %s: %s", id, err)
+	}
+}
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowWriter advances the clock by step on each write.
+type slowWriter struct {
+	bytes.Buffer
+	clock *fakeClock
+	step  time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.clock.Advance(w.step)
+	return w.Buffer.Write(p)
+}
+
+func (w *slowWriter) Close() error {
+	return nil
+}
+
+// slowConn advances the clock by step on each write.
+type slowConn struct {
+	net.Conn
+	clock *fakeClock
+	step  time.Duration
+}
+
+func (c slowConn) Write(p []byte) (int, error) {
+	c.clock.Advance(c.step)
+	return len(p), nil
+}
+
+func TestSlowFlush(t *testing.T) {
+	clock := newFakeClock()
+	tests := []struct {
+		step time.Duration
+		want string
+		slow uint64
+	}{
+		{500 * time.Millisecond, "a:x\n", 0},
+		{2 * time.Second, "a:x\nslow:flush:out:2000\n", 1},
+	}
+	for _, tt := range tests {
+		out := &slowWriter{clock: clock, step: tt.step}
+		b := New(ioutil.NopCloser(strings.NewReader("")), out, WithClock(clock), WithSlowThreshold(time.Second))
+		if err := b.emit(context.Background(), "a:x"); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+		if got := atomic.LoadUint64(&b.slowCount); got != tt.slow {
+			t.Errorf("got %d slow operations, want %d", got, tt.slow)
+		}
+	}
+}
+
+func TestSlowWrite(t *testing.T) {
+	clock := newFakeClock()
+	out := &slowWriter{clock: clock}
+	b := New(ioutil.NopCloser(strings.NewReader("")), out, WithClock(clock), WithSlowThreshold(time.Second))
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	c := newConnection(slowConn{Conn: server, clock: clock, step: 3 * time.Second}, "42", 1)
+	if err := b.writeTo(context.Background(), c, []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "slow:write:42:3000\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := atomic.LoadUint64(&b.slowCount); got != 1 {
+		t.Errorf("got %d slow operations, want 1", got)
+	}
+}
+
+func TestSlowDisabled(t *testing.T) {
+	clock := newFakeClock()
+	out := &slowWriter{clock: clock, step: time.Hour}
+	b := New(ioutil.NopCloser(strings.NewReader("")), out, WithClock(clock))
+	if err := b.emit(context.Background(), "a:x"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "a:x\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
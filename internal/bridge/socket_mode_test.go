@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSocketMode(t *testing.T) {
+	for _, mode := range []os.FileMode{0600, 0660} {
+		dir, err := ioutil.TempDir("", "gi-bridge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "bridge.sock")
+
+		h := startOn(t, "unix://"+path, WithSocketMode(mode))
+		defer h.close()
+		h.expectPrefix("a:")
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode()&os.ModeSocket == 0 {
+			t.Errorf("%s is not a socket: %s", path, fi.Mode())
+		}
+		if got := fi.Mode().Perm(); got != mode {
+			t.Errorf("got %o, want %o", got, mode)
+		}
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		h.expect("c:1")
+	}
+}
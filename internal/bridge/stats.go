@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+)
+
+const prefixStats = "stats"
+
+type stats struct {
+	Connections int    `json:"connections"`
+	BytesIn     uint64 `json:"bytesIn"`
+	BytesOut    uint64 `json:"bytesOut"`
+	Errors      uint64 `json:"errors"`
+	Drops       uint64 `json:"drops"`
+	Slow        uint64 `json:"slow"`
+	InFlight    int    `json:"inFlight"`
+}
+
+// emitStats writes a 'stats' line every stats interval with the number of
+// active connections and the bytes, errors, drops, and slow operations since
+// the last line, and the number of data in flight.
+func (b *bridge) emitStats(ctx context.Context) error {
+	var last stats
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.clock.After(b.statsInterval):
+		}
+		total := stats{
+			BytesIn:  atomic.LoadUint64(&b.totalIn),
+			BytesOut: atomic.LoadUint64(&b.totalOut),
+			Errors:   atomic.LoadUint64(&b.errorCount),
+			Drops:    atomic.LoadUint64(&b.dropCount),
+			Slow:     atomic.LoadUint64(&b.slowCount),
+		}
+		delta := stats{
+			Connections: len(b.connections()),
+			InFlight:    b.InFlight(),
+			BytesIn:     total.BytesIn - last.BytesIn,
+			BytesOut:    total.BytesOut - last.BytesOut,
+			Errors:      total.Errors - last.Errors,
+			Drops:       total.Drops - last.Drops,
+			Slow:        total.Slow - last.Slow,
+		}
+		last = total
+		p, err := json.Marshal(delta)
+		if err != nil {
+			return err
+		}
+		if err := b.emit(ctx, "%s:%s", prefixStats, p); err != nil && ctx.Err() == nil {
+			log.Printf("warn: failed to write stats: %s", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextStats advances the clock by the stats interval and returns the stats.
+func (h *harness) nextStats(clock *fakeClock) stats {
+	h.t.Helper()
+	clock.waitTimers(h.t, 1)
+	clock.Advance(time.Second)
+	line := h.until(prefixStats + delim)
+	var s stats
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, prefixStats+delim)), &s); err != nil {
+		h.t.Fatalf("failed to unmarshal %q: %s", line, err)
+	}
+	return s
+}
+
+func TestStats(t *testing.T) {
+	clock := newFakeClock()
+	h := start(t, WithClock(clock), WithStatsInterval(time.Second))
+	defer h.close()
+
+	if got, want := h.nextStats(clock), (stats{}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	h.quiet(50 * time.Millisecond)
+
+	conn, id := h.dial()
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("r:" + id + ":hello")
+	h.send("%s:hi", id)
+	readPeer(t, conn)
+	h.send("12345:lost")
+	h.send("!addr")
+	h.expect("a:" + h.addr)
+	if got, want := h.nextStats(clock), (stats{Connections: 1, BytesIn: 6, BytesOut: 3, Errors: 1}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// Bytes and errors are counted since the last line
+	if got, want := h.nextStats(clock), (stats{Connections: 1}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsInFlight(t *testing.T) {
+	clock := newFakeClock()
+	h := start(t, WithClock(clock), WithStatsInterval(time.Second), WithMaxInFlight(testInFlight))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	sent, _ := h.flood(id)
+	waitBackpressure(t, h.b, sent)
+	if got := h.nextStats(clock).InFlight; got != testInFlight {
+		t.Errorf("got %d data in flight, want %d", got, testInFlight)
+	}
+}
+
+func TestStatsStopsOnShutdown(t *testing.T) {
+	clock := newFakeClock()
+	h := start(t, WithClock(clock), WithStatsInterval(time.Second))
+	defer h.close()
+
+	clock.waitTimers(t, 1)
+	h.cancel()
+	h.expectPrefix(prefixShutdownSummary + delim)
+	if err := h.stop(); err != nil {
+		t.Fatalf("Start returned an error: %s", err)
+	}
+}
@@ -0,0 +1,45 @@
+package bridge
+
+import "testing"
+
+func TestStreamHeader(t *testing.T) {
+	h := start(t, WithStreamHeader("[{stream}] "))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{id + ":s1:hello", "[s1] hello\n"},
+		{id + ":s2:world:with:colons", "[s2] world:with:colons\n"},
+		{id + "::empty", "[] empty\n"},
+	}
+	for _, tt := range tests {
+		h.send("%s", tt.line)
+		if got := readPeer(t, conn); got != tt.want {
+			t.Errorf("got %q for %q, want %q", got, tt.line, tt.want)
+		}
+	}
+
+	// A line without the stream is not sent
+	h.send("%s:hello", id)
+	h.send("%s:s1:next", id)
+	if got, want := readPeer(t, conn), "[s1] next\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStreamHeaderBytes(t *testing.T) {
+	h := start(t, WithStreamHeader("{stream}\x00{stream}\x01"), WithInboundEncoding(EncodingBase64))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	// "hello"
+	h.send("%s:7:aGVsbG8=", id)
+	if got, want := readPeer(t, conn), "7\x007\x01hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gi-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ready")
+
+	h := start(t, WithReadyFile(path))
+	defer h.close()
+	h.send("!sync")
+	h.expect(prefixSync + delim + "ok")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("the ready file does not exist after 'sync:ok': %s", err)
+	}
+
+	// The ready file is gone out of the bridge
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	h.send("!sync")
+	h.expectPrefix(prefixSync + delim + "error" + delim)
+}
+
+func TestSyncErrorEscaped(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("a file name cannot contain a line break on Windows")
+	}
+	dir, err := ioutil.TempDir("", "gi-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rea\ndy")
+
+	h := start(t, WithReadyFile(path))
+	defer h.close()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	// The line break in the error is escaped so that it stays a line
+	h.send("!sync")
+	if got := h.expectPrefix(prefixSync + delim + "error" + delim); !strings.Contains(got, `rea\ndy`) {
+		t.Errorf("got %q, want the escaped path", got)
+	}
+	h.send("!addr")
+	h.expect(prefixAddress + delim + h.addr)
+}
+
+func TestSyncWithoutSideFiles(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("!sync")
+	h.expect(prefixSync + delim + "ok")
+}
+
+func TestSyncBeforeListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gi-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ready")
+
+	h := startOn(t, "127.0.0.1:0", WithReadyFile(path), WithLazyListen(true))
+	defer h.close()
+	h.send("!sync")
+	h.expect(prefixSync + delim + "ok")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the ready file exists before '!listen': %v", err)
+	}
+}
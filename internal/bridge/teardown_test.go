@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logBuffer captures the log output while the test runs.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func captureLog() (*logBuffer, func()) {
+	l := &logBuffer{}
+	log.SetOutput(l)
+	return l, func() { log.SetOutput(os.Stderr) }
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func TestTeardownWithSimultaneousDisconnections(t *testing.T) {
+	logs, restore := captureLog()
+	defer restore()
+
+	for i := 0; i < 20; i++ {
+		h := start(t)
+		ids := map[string]int{}
+		var conns []net.Conn
+		for j := 0; j < 4; j++ {
+			conn, id := h.dial()
+			ids[id] = 0
+			conns = append(conns, conn)
+		}
+		var wg sync.WaitGroup
+		for _, conn := range conns {
+			wg.Add(1)
+			go func(conn net.Conn) {
+				defer wg.Done()
+				conn.Close()
+			}(conn)
+		}
+		// Reach EOF of in while the peers are closing
+		if err := h.stop(); err != nil {
+			t.Fatalf("Start returned an error: %s", err)
+		}
+		wg.Wait()
+		h.cancel()
+
+		var last string
+		for line := range h.lines {
+			last = line
+			if !strings.HasPrefix(line, prefixDisconnect+delim) {
+				continue
+			}
+			id := strings.SplitN(strings.TrimPrefix(line, prefixDisconnect+delim), delim, 2)[0]
+			ids[id]++
+		}
+		for id, n := range ids {
+			if n != 1 {
+				t.Errorf("got %d disconnections of %s, want 1", n, id)
+			}
+		}
+		if !strings.HasPrefix(last, prefixShutdownSummary+delim) {
+			t.Errorf("got %q as the last line, want the shutdown summary", last)
+		}
+	}
+	if strings.Contains(logs.String(), "failed to write") {
+		t.Errorf("got write failures logged:\n%s", logs)
+	}
+}
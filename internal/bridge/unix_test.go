@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gi-bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bridge.sock")
+	h := startOn(t, "unix://"+path)
+	defer h.close()
+	h.expect(prefixAddress + delim + path)
+
+	// A Unix domain socket has no port so the sequential numbers are the keys
+	var conns []net.Conn
+	for _, id := range []string{"1", "2"} {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		h.expect(prefixConnect + delim + id)
+		conns = append(conns, conn)
+	}
+	h.send("2:hello")
+	if got, want := readPeer(t, conns[1]), "hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := conns[0].Write([]byte("world\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + "1" + delim + "world")
+	conns[0].Close()
+	h.expect(prefixDisconnect + delim + "1")
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPortValidation(t *testing.T) {
+	h := start(t, WithPortValidation(true))
+	defer h.close()
+	conn, id := h.dial()
+	defer conn.Close()
+
+	for _, token := range []string{"abc", "-1", "1.5", "0x10", ""} {
+		h.send("%s:hello", token)
+		h.expect(prefixError + delim + token + delim + "invalid-port")
+	}
+	h.send("%s:hello", id)
+	if got, want := readPeer(t, conn), "hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// A numeric token without a connection is not invalid
+	h.send("12345:hello")
+	h.quiet(50 * time.Millisecond)
+}
+
+func TestPortValidationDisabled(t *testing.T) {
+	h := start(t)
+	defer h.close()
+
+	h.send("abc:hello")
+	h.quiet(50 * time.Millisecond)
+}
+
+func TestPortValidationCustomKey(t *testing.T) {
+	h := start(t, WithPortValidation(true), WithConnKeyFormat(func(info ConnInfo) string {
+		return fmt.Sprintf("conn-%d", info.Seq)
+	}))
+	defer h.close()
+	conn := h.dialRaw()
+	defer conn.Close()
+	h.expect("c:conn-1")
+
+	h.send("conn-1:hello")
+	if got, want := readPeer(t, conn), "hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
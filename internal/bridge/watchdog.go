@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	prefixWarn = "warn"
+
+	// minWatchInterval bounds how often the watchdog checks for stalls.
+	minWatchInterval = time.Millisecond
+)
+
+// watchIncoming warns when handleIncoming has been handling a single line
+// for longer than the stall timeout, which means that sending has halted
+// (e.g. writing to out blocks). It warns once per stalled line, and
+// handleIncoming notifies the recovery when the line is handled (see
+// recoverIncoming).
+func (b *bridge) watchIncoming(ctx context.Context) error {
+	interval := b.stallTimeout / 2
+	if interval < minWatchInterval {
+		interval = minWatchInterval
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.clock.After(interval):
+		}
+		b.checkIncoming(ctx)
+	}
+}
+
+func (b *bridge) checkIncoming(ctx context.Context) {
+	b.stallMu.Lock()
+	defer b.stallMu.Unlock()
+	since := atomic.LoadInt64(&b.incomingSince)
+	if since == 0 || since == b.stalledSince {
+		return
+	}
+	d := b.clock.Now().Sub(time.Unix(0, since))
+	if d < b.stallTimeout {
+		return
+	}
+	b.stalledSince = since
+	log.Printf("warn: handling incoming data has stalled for %s", d)
+	if err := b.emit(ctx, "%s:stdin-stalled:%d", prefixWarn, d/time.Millisecond); err != nil && ctx.Err() == nil {
+		log.Printf("warn: failed to write stdin stall: %s", err)
+	}
+}
+
+// recoverIncoming notifies that the line which handleIncoming started to
+// handle at since has been handled, when it has been warned as stalled.
+func (b *bridge) recoverIncoming(ctx context.Context, since int64) {
+	if b.stallTimeout <= 0 {
+		return
+	}
+	b.stallMu.Lock()
+	defer b.stallMu.Unlock()
+	if since != b.stalledSince {
+		return
+	}
+	b.stalledSince = 0
+	d := b.clock.Now().Sub(time.Unix(0, since))
+	log.Printf("info: handling incoming data has recovered after %s", d)
+	if err := b.emit(ctx, "%s:stdin-recovered:%d", prefixWarn, d/time.Millisecond); err != nil && ctx.Err() == nil {
+		log.Printf("warn: failed to write stdin recovery: %s", err)
+	}
+}
@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWatchIncoming(t *testing.T) {
+	clock := newFakeClock()
+	// An event which nobody consumes stalls handling the line
+	h := start(t, WithClock(clock), WithStallTimeout(time.Second), WithEvents(0, EventBlock))
+	defer h.close()
+
+	h.send("99:hello")
+	var warn string
+	for i := 0; warn == "" && i < 10; i++ {
+		clock.waitTimers(t, 1)
+		clock.Advance(500 * time.Millisecond)
+		select {
+		case warn = <-h.lines:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	var ms int
+	if _, err := fmt.Sscanf(warn, "warn:stdin-stalled:%d", &ms); err != nil {
+		t.Fatalf("got %q, want a stall: %v", warn, err)
+	}
+	if ms < 1000 {
+		t.Errorf("got %q, want a stall of the timeout or longer", warn)
+	}
+	// Warned once per stalled line
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+	h.quiet(50 * time.Millisecond)
+
+	select {
+	case <-h.b.Events():
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the event")
+	}
+	line := h.next()
+	var recovered int
+	if _, err := fmt.Sscanf(line, "warn:stdin-recovered:%d", &recovered); err != nil {
+		t.Fatalf("got %q, want a recovery: %v", line, err)
+	}
+	if recovered < ms+1000 {
+		t.Errorf("got %q, want the stall of %dms or longer", line, ms+1000)
+	}
+}
+
+func TestWatchIncomingShortTimeout(t *testing.T) {
+	// The interval of such a short timeout is clamped rather than panics
+	h := start(t, WithStallTimeout(time.Nanosecond))
+	defer h.close()
+
+	h.send("!addr")
+	h.until("a:" + h.addr)
+}
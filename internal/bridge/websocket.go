@@ -0,0 +1,197 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/websocket"
+)
+
+const schemeWebSocket = "ws://"
+
+// wsListener accepts WebSocket connections upgraded on a path of an HTTP
+// server as net.Conn.
+type wsListener struct {
+	net.Listener
+	server *http.Server
+	addr   wsAddr
+	conns  chan net.Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+// listenWebSocket listens on 'ws://host:port/path'. Origin is not checked so
+// that non-browser clients, which do not send it, are accepted as well.
+func listenWebSocket(addr string) (net.Listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", addr, err)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	l, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	wl := &wsListener{
+		Listener: l,
+		addr:     wsAddr{host: l.Addr().String(), path: path},
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, websocket.Server{Handler: wl.handle})
+	wl.server = &http.Server{Handler: mux}
+	go wl.server.Serve(l)
+	return wl, nil
+}
+
+// handle hands the connection over to Accept and blocks until it is closed,
+// since the connection is closed when the handler returns.
+func (l *wsListener) handle(ws *websocket.Conn) {
+	c := newWSConn(ws)
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		return
+	}
+	select {
+	case <-c.closed:
+	case <-l.done:
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, fmt.Errorf("accept %s: use of closed listener", l.addr)
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.server.Shutdown(context.Background())
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.addr
+}
+
+type wsAddr struct {
+	host string
+	path string
+}
+
+func (a wsAddr) Network() string {
+	return "ws"
+}
+
+func (a wsAddr) String() string {
+	return schemeWebSocket + a.host + a.path
+}
+
+// wsConn maps a WebSocket message to a frame. A text or binary message is
+// read as a line terminated by a newline, with backslashes and newlines in
+// the message escaped as '\\' and '\n' so that the message is never split.
+// The bridge unescapes it again unless the outbound encoding is raw (see
+// encodeFrame). A write is sent as a message without the trailing newline; a text message
+// when it is valid UTF-8 and a binary message otherwise.
+type wsConn struct {
+	*websocket.Conn
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	buf        bytes.Buffer
+	closed     chan struct{}
+	once       sync.Once
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	c := &wsConn{
+		Conn:   ws,
+		closed: make(chan struct{}),
+	}
+	// websocket.Conn reports the origin as the remote address, so use the
+	// addresses of the underlying HTTP connection instead.
+	r := ws.Request()
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		c.remoteAddr = addr
+	} else {
+		c.remoteAddr = ws.RemoteAddr()
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.localAddr = addr
+	} else {
+		c.localAddr = ws.LocalAddr()
+	}
+	return c
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	if c.buf.Len() == 0 {
+		var msg []byte
+		if err := websocket.Message.Receive(c.Conn, &msg); err != nil {
+			return 0, err
+		}
+		wsEscaper.WriteString(&c.buf, string(msg))
+		c.buf.WriteByte('\n')
+	}
+	return c.buf.Read(p)
+}
+
+var (
+	wsEscaper   = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	wsUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n")
+)
+
+// encodeFrame encodes a frame read from the peer of c by the outbound
+// encoding. A WebSocket message is unescaped unless the encoding is raw,
+// since the other encodings carry backslashes and newlines by themselves.
+// The caller must lock outMu.
+func (b *bridge) encodeFrame(c *connection, data string) string {
+	if c.escaped && b.outboundEncoding != EncodingRaw {
+		data = wsUnescaper.Replace(data)
+	}
+	return b.outboundEncoding.encode(data)
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+	var err error
+	if utf8.Valid(msg) {
+		err = websocket.Message.Send(c.Conn, string(msg))
+	} else {
+		err = websocket.Message.Send(c.Conn, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
@@ -0,0 +1,118 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type wsMessage struct {
+	payloadType byte
+	data        string
+}
+
+// wsCodec receives a message together with its payload type.
+var wsCodec = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		*v.(*wsMessage) = wsMessage{payloadType, string(data)}
+		return nil
+	},
+}
+
+// startWebSocket starts a bridge on a WebSocket address and dials it.
+func startWebSocket(t *testing.T, opts ...Option) (*harness, *websocket.Conn, string) {
+	t.Helper()
+	h := startOn(t, "ws://127.0.0.1:0/bridge", opts...)
+	h.addr = strings.TrimPrefix(h.expectPrefix(prefixAddress+delim), prefixAddress+delim)
+	if !strings.HasPrefix(h.addr, schemeWebSocket) || !strings.HasSuffix(h.addr, "/bridge") {
+		t.Fatalf("got address %q, want ws://host:port/bridge", h.addr)
+	}
+	ws, err := websocket.Dial(h.addr, "", "http://localhost/")
+	if err != nil {
+		h.close()
+		t.Fatalf("failed to dial %s: %s", h.addr, err)
+	}
+	id := strings.TrimPrefix(h.expectPrefix(prefixConnect+delim), prefixConnect+delim)
+	return h, ws, id
+}
+
+func receiveWS(t *testing.T, ws *websocket.Conn) wsMessage {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(testTimeout))
+	var msg wsMessage
+	if err := wsCodec.Receive(ws, &msg); err != nil {
+		t.Fatalf("failed to receive a message: %s", err)
+	}
+	return msg
+}
+
+func TestWebSocket(t *testing.T) {
+	h, ws, id := startWebSocket(t)
+	defer h.close()
+
+	if err := websocket.Message.Send(ws, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + id + delim + "hello")
+	if err := websocket.Message.Send(ws, []byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + id + delim + "binary")
+
+	h.send("%s:hi", id)
+	if got, want := receiveWS(t, ws), (wsMessage{websocket.TextFrame, "hi"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	ws.Close()
+	h.expect(prefixDisconnect + delim + id)
+}
+
+func TestWebSocketMessageWithNewlines(t *testing.T) {
+	h, ws, id := startWebSocket(t)
+	defer h.close()
+	defer ws.Close()
+
+	if err := websocket.Message.Send(ws, "first\nsecond\\n\n"); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + id + delim + `first\nsecond\\n\n`)
+	if err := websocket.Message.Send(ws, "next"); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(prefixReceive + delim + id + delim + "next")
+}
+
+func TestWebSocketBase64(t *testing.T) {
+	h, ws, id := startWebSocket(t, WithOutboundEncoding(EncodingBase64))
+	defer h.close()
+	defer ws.Close()
+
+	// The message is not escaped since base64 carries any bytes
+	for _, msg := range []string{`a\b`, "first\nsecond\n"} {
+		if err := websocket.Message.Send(ws, msg); err != nil {
+			t.Fatal(err)
+		}
+		h.expect(prefixReceive + delim + id + delim + base64.StdEncoding.EncodeToString([]byte(msg)))
+	}
+}
+
+func TestWebSocketBinaryFrame(t *testing.T) {
+	h, ws, id := startWebSocket(t, WithInboundEncoding(EncodingBase64))
+	defer h.close()
+	defer ws.Close()
+
+	// "\xff\xfe" is not valid UTF-8
+	h.send("%s://4=", id)
+	if got, want := receiveWS(t, ws), (wsMessage{websocket.BinaryFrame, "\xff\xfe"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// "hi"
+	h.send("%s:aGk=", id)
+	if got, want := receiveWS(t, ws), (wsMessage{websocket.TextFrame, "hi"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
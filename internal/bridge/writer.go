@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/lambdalisue/gi.bridge/internal/pkg/ctxio"
+)
+
+const (
+	defaultWriteRetry    = 3
+	writeRetryBackoff    = 10 * time.Millisecond
+	writeRetryBackoffMax = 500 * time.Millisecond
+)
+
+// emit writes a single protocol line to out.
+//
+// Lines are serialized so that lines written from different goroutines are
+// never interleaved.
+func (b *bridge) emit(ctx context.Context, format string, a ...interface{}) error {
+	return b.emitLine(ctx, func() string {
+		return fmt.Sprintf(format, a...)
+	})
+}
+
+// emitLine writes a single protocol line which line builds while no other
+// line is being written.
+func (b *bridge) emitLine(ctx context.Context, line func() string) error {
+	b.outMu.Lock()
+	start := b.clock.Now()
+	err := b.write(ctx, b.out, []byte(line()+"\n"))
+	b.outMu.Unlock()
+	b.observe(ctx, "flush", "out", start)
+	return err
+}
+
+// write writes p to out and retries with backoff up to 'writeRetry' times
+// when the write fails by a transient error. The caller must lock outMu.
+//
+// Bytes which were written before the failure are not written again so that
+// a retry never duplicates nor drops data.
+func (b *bridge) write(ctx context.Context, out io.Writer, p []byte) error {
+	w := ctxio.Writer(ctx, out)
+	backoff := writeRetryBackoff
+	for attempt := 0; ; attempt++ {
+		n, err := w.Write(p)
+		if err == nil {
+			return nil
+		}
+		if attempt >= b.writeRetry || !isTransient(err) {
+			return err
+		}
+		p = p[n:]
+		log.Printf("debug: retry writing in %s (%d/%d): %s", backoff, attempt+1, b.writeRetry, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > writeRetryBackoffMax {
+			backoff = writeRetryBackoffMax
+		}
+	}
+}
+
+// isClosedPipe reports whether err is caused by out which the editor has
+// closed, which is expected while shutting down.
+func isClosedPipe(err error) bool {
+	return errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EPIPE)
+}
+
+// isTransient reports whether err is a temporary failure which may succeed
+// on retry (e.g. a socket buffer which is momentarily full).
+func isTransient(err error) bool {
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOBUFS) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return ne.Temporary() && !ne.Timeout()
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// flakyWriter writes a part of the first write and fails with err, then
+// writes everything.
+type flakyWriter struct {
+	bytes.Buffer
+	err    error
+	writes int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes == 1 {
+		n, _ := w.Buffer.Write(p[:len(p)/2])
+		return n, w.err
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *flakyWriter) Close() error {
+	return nil
+}
+
+func newTestBridge(out *flakyWriter, opts ...Option) *bridge {
+	return New(ioutil.NopCloser(strings.NewReader("")), out, opts...)
+}
+
+func TestWriteRetryTransient(t *testing.T) {
+	out := &flakyWriter{err: syscall.EAGAIN}
+	b := newTestBridge(out)
+	if err := b.emit(context.Background(), "r:42:%s", "hello"); err != nil {
+		t.Fatalf("emit failed: %s", err)
+	}
+	if got, want := out.String(), "r:42:hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if out.writes != 2 {
+		t.Errorf("got %d writes, want 2", out.writes)
+	}
+}
+
+func TestWriteRetryNonTransient(t *testing.T) {
+	out := &flakyWriter{err: syscall.EPIPE}
+	b := newTestBridge(out)
+	if err := b.emit(context.Background(), "r:42:%s", "hello"); err == nil {
+		t.Fatal("emit succeeded, want an error")
+	}
+	if out.writes != 1 {
+		t.Errorf("got %d writes, want 1", out.writes)
+	}
+}
+
+func TestWriteRetryDisabled(t *testing.T) {
+	out := &flakyWriter{err: syscall.EAGAIN}
+	b := newTestBridge(out, WithWriteRetry(0))
+	if err := b.emit(context.Background(), "r:42:%s", "hello"); err == nil {
+		t.Fatal("emit succeeded, want an error")
+	}
+	if out.writes != 1 {
+		t.Errorf("got %d writes, want 1", out.writes)
+	}
+}
@@ -0,0 +1,222 @@
+// Package cryptio wraps line-oriented streams so that each line is sealed by
+// AES-256-GCM with a key derived from a pre-shared key.
+//
+// A sealed line is the standard base64 encoding of a random 12 bytes nonce
+// followed by the ciphertext of the line (without the trailing newline),
+// terminated by a newline. The key is HMAC-SHA256 of the label, a zero byte,
+// and the session keyed by the pre-shared key, so that each direction of a
+// channel can use a distinct key by a distinct label, and each session of it
+// a distinct key by a distinct session.
+//
+// The session is a random nonce which one end of the channel chooses by
+// NewSession and sends by WriteSession as a plain line before any sealed
+// line. Lines recorded in a session fail to open in another session.
+//
+// The additional data of a line is its sequence number in the stream, which
+// starts from 0, in 8 bytes big endian. A line which is not the next one of
+// the stream fails to open, so that replaying, reordering, or dropping lines
+// is detected.
+package cryptio
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sessionSize is the size of a session nonce in bytes.
+const sessionSize = 32
+
+// NewSession returns a random session nonce.
+func NewSession() ([]byte, error) {
+	session := make([]byte, sessionSize)
+	if _, err := rand.Read(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// WriteSession writes the session as a line of its standard base64 encoding.
+func WriteSession(w io.Writer, session []byte) error {
+	_, err := io.WriteString(w, base64.StdEncoding.EncodeToString(session)+"\n")
+	return err
+}
+
+// ReadSession reads a session line written by WriteSession.
+func ReadSession(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	session, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode session line: %w", err)
+	}
+	if len(session) != sessionSize {
+		return nil, fmt.Errorf("got a session of %d bytes, want %d bytes", len(session), sessionSize)
+	}
+	return session, nil
+}
+
+func newAEAD(psk []byte, label string, session []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write([]byte(label))
+	mac.Write([]byte{0})
+	mac.Write(session)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sequence returns the additional data of the line of seq.
+func sequence(seq uint64) []byte {
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, seq)
+	return ad
+}
+
+// Reader wraps an io.Reader of sealed lines of the session with one that
+// reads the opened lines. Read fails when a line cannot be opened (e.g. it
+// has been forged, it is not the next line, or it is of another session) and
+// keeps failing afterwards.
+func Reader(r io.Reader, psk []byte, label string, session []byte) (io.Reader, error) {
+	aead, err := newAEAD(psk, label, session)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: bufio.NewReader(r), aead: aead}, nil
+}
+
+type reader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+	err  error
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+		if err != nil {
+			r.err = fmt.Errorf("failed to decode sealed line %d: %w", r.seq, err)
+			return 0, r.err
+		}
+		size := r.aead.NonceSize()
+		if len(sealed) < size {
+			r.err = fmt.Errorf("sealed line %d is too short", r.seq)
+			return 0, r.err
+		}
+		plain, err := r.aead.Open(nil, sealed[:size], sealed[size:], sequence(r.seq))
+		if err != nil {
+			r.err = fmt.Errorf("failed to open sealed line %d (forged, replayed, reordered, or dropped): %w", r.seq, err)
+			return 0, r.err
+		}
+		r.seq++
+		r.buf = append(plain, '\n')
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Writer wraps an io.Writer with one that writes each line sealed in the
+// session.
+//
+// Bytes are held until a newline is written, so callers should write complete
+// lines. When writing a sealed line fails without writing anything, Write
+// returns the number of bytes of p before the line so that the caller can
+// write the rest again. When a sealed line has been partly written, the
+// stream is broken and Write keeps failing by an error which is not
+// temporary.
+func Writer(w io.Writer, psk []byte, label string, session []byte) (io.Writer, error) {
+	aead, err := newAEAD(psk, label, session)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead}, nil
+}
+
+type writer struct {
+	w    io.Writer
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+	err  error
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	held := len(w.buf)
+	w.buf = append(w.buf, p...)
+	// start is the beginning of the line to write in buf
+	start := 0
+	for {
+		i := bytes.IndexByte(w.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		end := start + i + 1
+		written, err := w.writeLine(w.buf[start : end-1])
+		if written {
+			start = end
+		}
+		if err != nil {
+			// Keep holding the bytes held before p which have not been
+			// written, and report the bytes of p written.
+			if start < held {
+				w.buf = append(w.buf[:0], w.buf[start:held]...)
+				return 0, err
+			}
+			w.buf = w.buf[:0]
+			return start - held, err
+		}
+	}
+	w.buf = append(w.buf[:0], w.buf[start:]...)
+	return len(p), nil
+}
+
+// writeLine writes plain as the next sealed line. It reports whether the
+// sealed line has been written entirely.
+func (w *writer) writeLine(plain []byte) (bool, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return false, err
+	}
+	sealed := w.aead.Seal(nonce, nonce, plain, sequence(w.seq))
+	line := base64.StdEncoding.EncodeToString(sealed) + "\n"
+	n, err := io.WriteString(w.w, line)
+	switch {
+	case n == len(line):
+		w.seq++
+		return true, err
+	case n > 0:
+		// The rest of the line cannot be written as a line again. The error
+		// is formatted rather than wrapped so that it is never taken as a
+		// temporary one to retry.
+		w.err = fmt.Errorf("failed to write sealed line %d which has been partly written: %v", w.seq, err)
+		return false, w.err
+	default:
+		return false, err
+	}
+}
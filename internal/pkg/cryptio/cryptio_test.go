@@ -0,0 +1,256 @@
+package cryptio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+var psk = []byte("0123456789abcdef0123456789abcdef")
+
+var session = bytes.Repeat([]byte{1}, sessionSize)
+
+const label = "test"
+
+// seal returns the sealed lines of the lines.
+func seal(t *testing.T, lines ...string) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := Writer(&buf, psk, label, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return split(buf.String())
+}
+
+// split splits s into lines without the trailing newlines.
+func split(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// open reads the opened lines of the sealed lines until it fails.
+func open(t *testing.T, sealed ...string) ([]string, error) {
+	t.Helper()
+	r, err := Reader(strings.NewReader(strings.Join(sealed, "\n")+"\n"), psk, label, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return lines, err
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := Writer(&buf, psk, label, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lines are sealed once they are complete
+	for _, p := range []string{"he", "llo\nwor", "ld\n", "\n"} {
+		if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatalf("Write(%q) = %d, %v", p, n, err)
+		}
+	}
+	if strings.Contains(buf.String(), "hello") {
+		t.Fatal("the line is written in plain text")
+	}
+	got, err := open(t, split(buf.String())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"hello", "world", ""}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReaderRejects(t *testing.T) {
+	sealed := seal(t, "a", "b", "c")
+	forged := []byte(sealed[1])
+	forged[len(forged)/2] ^= 1
+	tests := []struct {
+		name   string
+		sealed []string
+		want   []string
+	}{
+		{"replayed", []string{sealed[0], sealed[0]}, []string{"a"}},
+		{"reordered", []string{sealed[1], sealed[0]}, nil},
+		{"dropped", []string{sealed[0], sealed[2]}, []string{"a"}},
+		{"forged", []string{sealed[0], string(forged)}, []string{"a"}},
+		{"not base64", []string{"!"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := open(t, tt.sealed...)
+		if err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReaderWrongLabel(t *testing.T) {
+	r, err := Reader(strings.NewReader(seal(t, "a")[0]+"\n"), psk, "other", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestReaderOtherSession(t *testing.T) {
+	other, err := NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Reader(strings.NewReader(seal(t, "a")[0]+"\n"), psk, label, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestSession(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSession(&buf, session); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("rest\n")
+	r := bufio.NewReader(&buf)
+	got, err := ReadSession(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, session) {
+		t.Errorf("got %x, want %x", got, session)
+	}
+	// Nothing after the session line is consumed
+	if rest, _ := r.ReadString('\n'); rest != "rest\n" {
+		t.Errorf("got %q, want %q", rest, "rest\n")
+	}
+
+	if _, err := ReadSession(bufio.NewReader(strings.NewReader("c2hvcnQ=\n"))); err == nil {
+		t.Error("got no error for a short session")
+	}
+}
+
+// failingWriter writes n bytes of the write of index at and fails with err.
+type failingWriter struct {
+	buf    bytes.Buffer
+	at     int
+	n      int
+	err    error
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes == w.at {
+		n, _ := w.buf.Write(p[:w.n])
+		return n, w.err
+	}
+	return w.buf.Write(p)
+}
+
+func TestWriterRetry(t *testing.T) {
+	out := &failingWriter{at: 3, err: syscall.EAGAIN}
+	w, err := Writer(out, psk, label, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 'x' is held by the first write
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	p := []byte("y\nz\nw\n")
+	// 'xy' and 'z' are written and 'w' fails without writing anything
+	n, err := w.Write(p)
+	if !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("got %v, want EAGAIN", err)
+	}
+	if n != 4 {
+		t.Fatalf("got %d, want 4", n)
+	}
+	if _, err := w.Write(p[n:]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := open(t, split(out.buf.String())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"xy", "z", "w"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriterRetryHeld(t *testing.T) {
+	out := &failingWriter{at: 1, err: syscall.EAGAIN}
+	w, err := Writer(out, psk, label, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing of p is written and 'x' is still held
+	p := []byte("y\n")
+	if n, err := w.Write(p); n != 0 || err == nil {
+		t.Fatalf("got %d, %v, want 0 and an error", n, err)
+	}
+	if _, err := w.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := open(t, split(out.buf.String())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"xy"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriterTornLine(t *testing.T) {
+	out := &failingWriter{at: 2, n: 5, err: syscall.EAGAIN}
+	w, err := Writer(out, psk, label, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Write([]byte("a\nb\n"))
+	if err == nil || errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("got %v, want an error which is not temporary", err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d, want 2", n)
+	}
+	// The stream is broken
+	if _, err := w.Write([]byte("b\n")); err == nil {
+		t.Fatal("got no error after the torn line")
+	}
+	if out.writes != 2 {
+		t.Errorf("got %d writes, want 2", out.writes)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/comail/colog"
 
@@ -19,25 +25,147 @@ var (
 func main() {
 	colog.Register()
 	var (
-		version = flag.Bool("version", false, "show version")
-		addr    = flag.String("addr", "127.0.0.1:0", "TCP address to listen")
+		version       = flag.Bool("version", false, "show version")
+		addr          = flag.String("addr", "127.0.0.1:0", "address to listen (host:port, unix://path, npipe://./pipe/name, or ws://host:port/path)")
+		control       = flag.String("control-addr", "", "TCP address to connect for the control protocol instead of stdin/stdout")
+		inject        = flag.Bool("test-inject", false, "enable '!inject-*' commands for testing (never use in production)")
+		retry         = flag.Int("write-retry", 3, "number of retries on transient write failures")
+		single        = flag.String("single-connection", "", "allow only one connection at a time ('reject' or 'replace' the new one)")
+		ready         = flag.String("ready-file", "", "file created once ready and removed on exit")
+		inEnc         = flag.String("inbound-encoding", "raw", "encoding of data sent from the editor ('raw' or 'base64')")
+		outEnc        = flag.String("outbound-encoding", "raw", "encoding of data sent to the editor ('raw' or 'base64')")
+		newline       = flag.Bool("send-newline", true, "terminate data sent to peers with a newline")
+		slow          = flag.Duration("slow-threshold", 0, "log operations slower than the threshold (0 to disable)")
+		index         = flag.Bool("frame-index", false, "tag received data with a per-connection index (r:<port>:<index>:<data>)")
+		nodelay       = flag.Bool("tcp-nodelay", true, "disable Nagle's algorithm on TCP connections")
+		keyFile       = flag.String("control-key-file", "", "file of a pre-shared key to encrypt the control protocol")
+		sockMod       = flag.Uint("socket-mode", 0, "file mode of a Unix domain socket (e.g. 0600)")
+		greet         = flag.String("greeting", "", "data sent to each peer on connect")
+		dbatch        = flag.Duration("disconnect-batch", 0, "batch disconnections within the window into a dbatch line (0 to disable)")
+		stall         = flag.Duration("stall-timeout", 0, "warn when handling a line from stdin stalls longer than the timeout (0 to disable)")
+		last          = flag.Int("last-frame-size", 0, "retain the last received data per connection up to the size for '!last' (0 to disable)")
+		quota         = flag.Uint64("byte-quota", 0, "close a connection which transfers more bytes than the quota in total (0 to disable)")
+		flight        = flag.Int("max-in-flight", 0, "stop reading stdin while more data than the number are queued to peers in total (0 to disable)")
+		drain         = flag.Bool("drain-stdin", false, "handle the lines already read from stdin before shutting down by a signal")
+		errRing       = flag.Int("error-ring", 0, "retain the number of last errors for '!errors' (0 to disable)")
+		dedupFrames   = flag.Int("dedup-frames", 0, "collapse identical consecutive frames and report repeats up to the number at once in 'rrep' (0 to disable)")
+		lazyListen    = flag.Bool("lazy-listen", false, "listen on the address when '!listen' is received instead of on start")
+		streamHeader  = flag.String("stream-header", "", "read data as 'port:stream:expr' and prepend the header to data sent to peers, where '{stream}' is replaced with the stream")
+		statsInterval = flag.Duration("stats-interval", 0, "write a 'stats' line every interval (0 to disable)")
+		maxConns      = flag.Int("max-connections", 0, "cap the number of connections (0 to disable)")
+		admitTimeout  = flag.Duration("admission-timeout", 0, "how long a connection waits for a slot at -max-connections before it is rejected")
+		validatePort  = flag.Bool("validate-port", false, "reject data to non-numeric ports with 'e:<port>:invalid-port'")
+		replyTimeout  = flag.Duration("reply-timeout", 10*time.Second, "how long '!request' waits for the reply")
+		listenFd      = flag.Int("listen-fd", -1, "serve on the listening socket of the inherited file descriptor instead of -addr")
+		defaultRoute  = flag.String("default-route", "", "send data to unknown ports to the connection of the id, prefixed with the port")
 	)
 	flag.Parse()
 	if *version {
 		fmt.Println(appVersion)
 		os.Exit(0)
 	}
+	switch policy := bridge.SingleConnectionPolicy(*single); policy {
+	case bridge.SingleConnectionNone, bridge.SingleConnectionReject, bridge.SingleConnectionReplace:
+	default:
+		log.Fatalf("error: unknown single connection policy: %s\n", policy)
+	}
+	for _, e := range []string{*inEnc, *outEnc} {
+		switch bridge.Encoding(e) {
+		case bridge.EncodingRaw, bridge.EncodingBase64:
+		default:
+			log.Fatalf("error: unknown encoding: %s\n", e)
+		}
+	}
+
+	var controlKey []byte
+	if *keyFile != "" {
+		key, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			log.Fatalf("error: failed to read control key file: %s\n", err)
+		}
+		controlKey = bytes.TrimSpace(key)
+		if len(controlKey) == 0 {
+			log.Fatalf("error: control key file %s is empty\n", *keyFile)
+		}
+	}
+
+	var listener net.Listener
+	if *listenFd >= 0 {
+		l, err := bridge.FileListener(uintptr(*listenFd))
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		listener = l
+	}
 
-	exitCode, err := run(*addr)
+	exitCode, err := run(
+		*addr,
+		*control,
+		bridge.WithTestInject(*inject),
+		bridge.WithWriteRetry(*retry),
+		bridge.WithSingleConnection(bridge.SingleConnectionPolicy(*single)),
+		bridge.WithReadyFile(*ready),
+		bridge.WithInboundEncoding(bridge.Encoding(*inEnc)),
+		bridge.WithOutboundEncoding(bridge.Encoding(*outEnc)),
+		bridge.WithSendNewline(*newline),
+		bridge.WithSlowThreshold(*slow),
+		bridge.WithFrameIndex(*index),
+		bridge.WithTCPNoDelay(*nodelay),
+		bridge.WithControlChannelKey(controlKey),
+		bridge.WithSocketMode(os.FileMode(*sockMod)),
+		bridge.WithGreeting([]byte(*greet)),
+		bridge.WithDisconnectBatch(*dbatch),
+		bridge.WithStallTimeout(*stall),
+		bridge.WithLastFrameCache(*last),
+		bridge.WithConnectionByteQuota(*quota),
+		bridge.WithMaxInFlight(*flight),
+		bridge.WithDrainIncoming(*drain),
+		bridge.WithErrorRing(*errRing),
+		bridge.WithDefaultRoute(*defaultRoute),
+		bridge.WithReplyTimeout(*replyTimeout),
+		bridge.WithPortValidation(*validatePort),
+		bridge.WithMaxConnections(*maxConns),
+		bridge.WithAdmissionTimeout(*admitTimeout),
+		bridge.WithStatsInterval(*statsInterval),
+		bridge.WithStreamHeader(*streamHeader),
+		bridge.WithLazyListen(*lazyListen),
+		bridge.WithDedupFrames(*dedupFrames),
+		bridge.WithListener(listener),
+	)
 	if err != nil {
 		log.Fatalf("error: %s\n", err)
 	}
 	os.Exit(exitCode)
 }
 
-func run(addr string) (int, error) {
-	ctx := context.Background()
-	b := bridge.New(os.Stdin, os.Stdout)
+func run(addr, controlAddr string, opts ...bridge.Option) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, shutdownSignals...)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			log.Printf("info: shutting down by %s", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var (
+		in  io.ReadCloser  = os.Stdin
+		out io.WriteCloser = os.Stdout
+	)
+	if controlAddr != "" {
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			return 1, fmt.Errorf("failed to connect control address %s: %w", controlAddr, err)
+		}
+		defer conn.Close()
+		in, out = conn, conn
+	}
+	b := bridge.New(in, out, opts...)
 	if err := b.Start(ctx, addr); err != nil {
 		return 1, err
 	}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are signals which gracefully shut down the bridge.
+// SIGHUP is included as it is sent when the controlling terminal (e.g. the
+// editor) has gone.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are signals which gracefully shut down the bridge.
+// Windows has no SIGTERM nor SIGHUP; os.Interrupt is delivered on Ctrl-C and
+// Ctrl-Break, and Go 1.14 or later delivers syscall.SIGTERM on console close,
+// logoff, and shutdown events.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}